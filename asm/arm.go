@@ -0,0 +1,619 @@
+package asm
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+var conditions = map[string]uint32{
+	"eq": 0x0, "ne": 0x1, "cs": 0x2, "hs": 0x2, "cc": 0x3, "lo": 0x3,
+	"mi": 0x4, "pl": 0x5, "vs": 0x6, "vc": 0x7, "hi": 0x8, "ls": 0x9,
+	"ge": 0xA, "lt": 0xB, "gt": 0xC, "le": 0xD, "al": 0xE, "": 0xE,
+}
+
+var aluOpcodes = map[string]uint32{
+	"and": 0x0, "eor": 0x1, "sub": 0x2, "rsb": 0x3, "add": 0x4, "adc": 0x5, "sbc": 0x6, "rsc": 0x7,
+	"tst": 0x8, "teq": 0x9, "cmp": 0xA, "cmn": 0xB, "orr": 0xC, "mov": 0xD, "bic": 0xE, "mvn": 0xF,
+}
+
+var shiftTypes = map[string]uint32{"lsl": 0, "asl": 0, "lsr": 1, "asr": 2, "ror": 3}
+
+// armMnemonics lists each ARM mnemonic with the suffixes it takes besides
+// a condition.
+var armMnemonics = []struct {
+	base     string
+	suffixes []string
+}{
+	{"and", []string{"", "s"}}, {"eor", []string{"", "s"}}, {"sub", []string{"", "s"}}, {"rsb", []string{"", "s"}},
+	{"add", []string{"", "s"}}, {"adc", []string{"", "s"}}, {"sbc", []string{"", "s"}}, {"rsc", []string{"", "s"}},
+	{"tst", []string{""}}, {"teq", []string{""}}, {"cmp", []string{""}}, {"cmn", []string{""}},
+	{"orr", []string{"", "s"}}, {"mov", []string{"", "s"}}, {"bic", []string{"", "s"}}, {"mvn", []string{"", "s"}},
+	{"lsl", []string{"", "s"}}, {"lsr", []string{"", "s"}}, {"asr", []string{"", "s"}}, {"ror", []string{"", "s"}}, {"rrx", []string{"", "s"}},
+	{"mul", []string{"", "s"}}, {"mla", []string{"", "s"}},
+	{"umull", []string{"", "s"}}, {"umlal", []string{"", "s"}}, {"smull", []string{"", "s"}}, {"smlal", []string{"", "s"}},
+	{"ldr", []string{"", "b", "t", "bt", "h", "sb", "sh"}}, {"str", []string{"", "b", "t", "bt", "h"}},
+	{"ldm", []string{"ia", "ib", "da", "db", "fd", "fa", "ed", "ea"}}, {"stm", []string{"ia", "ib", "da", "db", "fd", "fa", "ed", "ea"}},
+	{"push", []string{""}}, {"pop", []string{""}},
+	{"swp", []string{"", "b"}},
+	{"b", []string{""}}, {"bl", []string{""}}, {"bx", []string{""}},
+	{"svc", []string{""}}, {"swi", []string{""}},
+	{"mrs", []string{""}}, {"msr", []string{""}},
+	{"adr", []string{""}}, {"nop", []string{""}},
+}
+
+// splitMnemonic splits an ARM mnemonic into its base, suffix and condition,
+// taking the condition on either side of the suffix.
+func splitMnemonic(mnemonic string) (base, suffix string, cond uint32, ok bool) {
+	for _, m := range armMnemonics {
+		rest, found := strings.CutPrefix(mnemonic, m.base)
+		if !found {
+			continue
+		}
+		for _, s := range m.suffixes {
+			for name, c := range conditions {
+				if rest == s+name || rest == name+s {
+					return m.base, s, c, true
+				}
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+func (a *assembler) arm(it *item) (uint32, error) {
+	base, suffix, cond, ok := splitMnemonic(it.mnemonic)
+	if !ok {
+		return 0, fmt.Errorf("unknown instruction %s", it.mnemonic)
+	}
+
+	opcode, err := a.armOpcode(it, base, suffix)
+	return cond<<28 | opcode, err
+}
+
+func (a *assembler) armOpcode(it *item, base, suffix string) (uint32, error) {
+	ops := it.operands
+	S := uint32(0)
+	if suffix == "s" {
+		S = 1
+	}
+
+	if opcode, ok := aluOpcodes[base]; ok {
+		return a.armALU(opcode, S, ops)
+	}
+
+	switch base {
+	case "lsl", "lsr", "asr", "ror", "rrx":
+		if base == "rrx" {
+			if len(ops) != 2 {
+				return 0, fmt.Errorf("rrx takes two registers")
+			}
+			return a.armALU(aluOpcodes["mov"], S, []string{ops[0], ops[1], "rrx"})
+		}
+		if len(ops) != 3 {
+			return 0, fmt.Errorf("%s takes a destination, a register and an amount", base)
+		}
+		return a.armALU(aluOpcodes["mov"], S, []string{ops[0], ops[1], base + " " + ops[2]})
+	case "nop":
+		return 0x01A00000, nil
+	case "adr":
+		return a.armAdr(it, ops)
+	case "mul", "mla":
+		return a.armMultiply(base, S, ops)
+	case "umull", "umlal", "smull", "smlal":
+		return a.armMultiplyLong(base, S, ops)
+	case "ldr", "str":
+		return a.armMemory(it, base, suffix, ops)
+	case "ldm", "stm":
+		return a.armMemoryBlock(base, suffix, ops)
+	case "push", "pop":
+		if len(ops) != 1 {
+			return 0, fmt.Errorf("%s takes a register list", base)
+		}
+		if base == "push" {
+			return a.armMemoryBlock("stm", "db", []string{"sp!", ops[0]})
+		}
+		return a.armMemoryBlock("ldm", "ia", []string{"sp!", ops[0]})
+	case "swp":
+		return a.armSwap(suffix, ops)
+	case "b", "bl":
+		return a.armBranch(it, base, ops)
+	case "bx":
+		if len(ops) != 1 {
+			return 0, fmt.Errorf("bx takes a register")
+		}
+		Rm, err := register(ops[0])
+		return 0x012FFF10 | Rm, err
+	case "svc", "swi":
+		if len(ops) != 1 {
+			return 0, fmt.Errorf("%s takes a comment", base)
+		}
+		comment, err := a.eval(ops[0], true)
+		if err == nil && comment > 0xFFFFFF {
+			err = fmt.Errorf("comment %#x does not fit in 24 bits", comment)
+		}
+		return 0x0F000000 | comment, err
+	case "mrs":
+		return a.armMRS(ops)
+	case "msr":
+		return a.armMSR(ops)
+	}
+
+	return 0, fmt.Errorf("unknown instruction %s", it.mnemonic)
+}
+
+// armImmediate encodes a value as an 8-bit immediate rotated right by an
+// even amount.
+func armImmediate(value uint32) (uint32, bool) {
+	for rotate := uint32(0); rotate < 16; rotate++ {
+		if imm := bits.RotateLeft32(value, int(rotate*2)); imm <= 0xFF {
+			return rotate<<8 | imm, true
+		}
+	}
+	return 0, false
+}
+
+// aluAlternatives pairs opcodes that can take a value their partner cannot
+// encode, by inverting or negating it.
+var aluAlternatives = map[uint32]struct {
+	opcode uint32
+	value  func(uint32) uint32
+}{
+	0x0: {0xE, func(v uint32) uint32 { return ^v }}, // AND, BIC
+	0xE: {0x0, func(v uint32) uint32 { return ^v }},
+	0xD: {0xF, func(v uint32) uint32 { return ^v }}, // MOV, MVN
+	0xF: {0xD, func(v uint32) uint32 { return ^v }},
+	0x5: {0x6, func(v uint32) uint32 { return ^v }}, // ADC, SBC
+	0x6: {0x5, func(v uint32) uint32 { return ^v }},
+	0x4: {0x2, func(v uint32) uint32 { return -v }}, // ADD, SUB
+	0x2: {0x4, func(v uint32) uint32 { return -v }},
+	0xA: {0xB, func(v uint32) uint32 { return -v }}, // CMP, CMN
+	0xB: {0xA, func(v uint32) uint32 { return -v }},
+}
+
+func (a *assembler) armALU(opcode, S uint32, ops []string) (uint32, error) {
+	var Rd, Rn uint32
+	var err error
+
+	switch opcode {
+	case 0x8, 0x9, 0xA, 0xB: // TST, TEQ, CMP, CMN
+		S = 1
+		if len(ops) < 2 {
+			return 0, fmt.Errorf("missing operand")
+		}
+		Rn, err = register(ops[0])
+		ops = ops[1:]
+	case 0xD, 0xF: // MOV, MVN
+		if len(ops) < 2 {
+			return 0, fmt.Errorf("missing operand")
+		}
+		Rd, err = register(ops[0])
+		ops = ops[1:]
+	default:
+		if len(ops) < 2 {
+			return 0, fmt.Errorf("missing operand")
+		}
+		Rd, err = register(ops[0])
+		Rn = Rd
+		ops = ops[1:]
+		// the first source may be left out when it is the destination
+		if len(ops) > 1 && isRegister(ops[0]) && (len(ops) > 2 || isRegister(ops[1]) || strings.HasPrefix(ops[1], "#")) {
+			Rn, err = register(ops[0])
+			ops = ops[1:]
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ops) == 1 && !isRegister(ops[0]) {
+		value, err := a.eval(ops[0], true)
+		if err != nil {
+			return 0, err
+		}
+		imm, ok := armImmediate(value)
+		if !ok {
+			alt, found := aluAlternatives[opcode]
+			if found {
+				imm, ok = armImmediate(alt.value(value))
+				opcode = alt.opcode
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("%#x cannot be encoded as an immediate", value)
+		}
+		return 1<<25 | opcode<<21 | S<<20 | Rn<<16 | Rd<<12 | imm, nil
+	}
+
+	op2, err := a.armShifted(ops, true)
+	return opcode<<21 | S<<20 | Rn<<16 | Rd<<12 | op2, err
+}
+
+// armShifted encodes a register operand and its optional shift, which may
+// be by a register only when allowed.
+func (a *assembler) armShifted(ops []string, registerShift bool) (uint32, error) {
+	if len(ops) == 0 || len(ops) > 2 {
+		return 0, fmt.Errorf("bad operand")
+	}
+
+	Rm, err := register(ops[0])
+	if err != nil {
+		return 0, err
+	}
+	if len(ops) == 1 {
+		return Rm, nil
+	}
+
+	fields := strings.Fields(strings.ToLower(ops[1]))
+	if len(fields) == 1 && fields[0] == "rrx" {
+		return 3<<5 | Rm, nil
+	}
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("bad shift %q", ops[1])
+	}
+	shift, ok := shiftTypes[fields[0]]
+	if !ok {
+		return 0, fmt.Errorf("bad shift %q", ops[1])
+	}
+
+	if isRegister(fields[1]) {
+		if !registerShift {
+			return 0, fmt.Errorf("cannot shift by a register here")
+		}
+		Rs, _ := register(fields[1])
+		return Rs<<8 | shift<<5 | 1<<4 | Rm, nil
+	}
+
+	amount, err := a.eval(fields[1], true)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case shift == 0 && amount <= 31, shift == 3 && amount >= 1 && amount <= 31:
+	case (shift == 1 || shift == 2) && amount >= 1 && amount <= 32:
+		amount %= 32
+	default:
+		return 0, fmt.Errorf("bad shift amount %d", amount)
+	}
+	return amount<<7 | shift<<5 | Rm, nil
+}
+
+// armAdr takes the address of a label with an ADD or SUB from PC.
+func (a *assembler) armAdr(it *item, ops []string) (uint32, error) {
+	if len(ops) != 2 {
+		return 0, fmt.Errorf("adr takes a register and an address")
+	}
+	Rd, err := register(ops[0])
+	if err != nil {
+		return 0, err
+	}
+	target, err := a.eval(ops[1], true)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := target - (it.address + 8)
+	opcode := aluOpcodes["add"]
+	if int32(offset) < 0 {
+		offset = -offset
+		opcode = aluOpcodes["sub"]
+	}
+	imm, ok := armImmediate(offset)
+	if !ok {
+		return 0, fmt.Errorf("%#x is out of range of adr", target)
+	}
+	return 1<<25 | opcode<<21 | 15<<16 | Rd<<12 | imm, nil
+}
+
+func (a *assembler) armMultiply(base string, S uint32, ops []string) (uint32, error) {
+	want := map[string]int{"mul": 3, "mla": 4}[base]
+	if len(ops) != want {
+		return 0, fmt.Errorf("%s takes %d registers", base, want)
+	}
+	r, err := registers(ops)
+	if err != nil {
+		return 0, err
+	}
+
+	opcode := S<<20 | r[0]<<16 | r[2]<<8 | 0x90 | r[1]
+	if base == "mla" {
+		opcode |= 1<<21 | r[3]<<12
+	}
+	return opcode, nil
+}
+
+func (a *assembler) armMultiplyLong(base string, S uint32, ops []string) (uint32, error) {
+	if len(ops) != 4 {
+		return 0, fmt.Errorf("%s takes 4 registers", base)
+	}
+	r, err := registers(ops)
+	if err != nil {
+		return 0, err
+	}
+
+	opcode := 0x00800090 | S<<20 | r[1]<<16 | r[0]<<12 | r[3]<<8 | r[2]
+	if base[0] == 's' {
+		opcode |= 1 << 22
+	}
+	if base[3:] == "al" {
+		opcode |= 1 << 21
+	}
+	return opcode, nil
+}
+
+func registers(ops []string) ([]uint32, error) {
+	r := make([]uint32, len(ops))
+	for i, op := range ops {
+		var err error
+		if r[i], err = register(op); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (a *assembler) armSwap(suffix string, ops []string) (uint32, error) {
+	if len(ops) != 3 || !strings.HasPrefix(ops[2], "[") || !strings.HasSuffix(ops[2], "]") {
+		return 0, fmt.Errorf("swp takes two registers and an address")
+	}
+	r, err := registers([]string{ops[0], ops[1], ops[2][1 : len(ops[2])-1]})
+	if err != nil {
+		return 0, err
+	}
+
+	opcode := 0x01000090 | r[2]<<16 | r[0]<<12 | r[1]
+	if suffix == "b" {
+		opcode |= 1 << 22
+	}
+	return opcode, nil
+}
+
+func (a *assembler) armBranch(it *item, base string, ops []string) (uint32, error) {
+	if len(ops) != 1 {
+		return 0, fmt.Errorf("%s takes a target", base)
+	}
+	target, err := a.eval(ops[0], true)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := int32(target - (it.address + 8))
+	if offset%4 != 0 || offset < -1<<25 || offset >= 1<<25 {
+		return 0, fmt.Errorf("%#x is out of range of %s", target, base)
+	}
+
+	opcode := 0x0A000000 | uint32(offset>>2)&0xFFFFFF
+	if base == "bl" {
+		opcode |= 1 << 24
+	}
+	return opcode, nil
+}
+
+// armMemory encodes single loads and stores, taking a literal or label in
+// place of the address as a load from PC.
+func (a *assembler) armMemory(it *item, base, suffix string, ops []string) (uint32, error) {
+	if len(ops) < 2 {
+		return 0, fmt.Errorf("%s takes a register and an address", base+suffix)
+	}
+	Rd, err := register(ops[0])
+	if err != nil {
+		return 0, err
+	}
+
+	L := uint32(0)
+	if base == "ldr" {
+		L = 1
+	}
+
+	m, err := a.armAddress(it, ops[1:])
+	if err != nil {
+		return 0, err
+	}
+
+	P, W := uint32(0), uint32(0)
+	if m.pre {
+		P = 1
+		if m.writeback {
+			W = 1
+		}
+	}
+
+	switch suffix {
+	case "h", "sb", "sh":
+		SH := map[string]uint32{"h": 1, "sb": 2, "sh": 3}[suffix]
+		opcode := P<<24 | W<<21 | L<<20 | m.base<<16 | Rd<<12 | 1<<7 | SH<<5 | 1<<4
+
+		if !m.immediate {
+			if m.shift != "" {
+				return 0, fmt.Errorf("halfword offsets cannot be shifted")
+			}
+			if !m.down {
+				opcode |= 1 << 23
+			}
+			return opcode | m.register, nil
+		}
+
+		offset, down, err := a.immediateOffset(m)
+		if err != nil {
+			return 0, err
+		}
+		if offset > 0xFF {
+			return 0, fmt.Errorf("offset %#x is out of range", offset)
+		}
+		if !down {
+			opcode |= 1 << 23
+		}
+		return opcode | 1<<22 | offset&0xF0<<4 | offset&0xF, nil
+	}
+
+	if strings.Contains(suffix, "t") {
+		if m.pre {
+			return 0, fmt.Errorf("%s needs a post-indexed address", base+suffix)
+		}
+		W = 1
+	}
+	opcode := 1<<26 | P<<24 | W<<21 | L<<20 | m.base<<16 | Rd<<12
+	if strings.Contains(suffix, "b") {
+		opcode |= 1 << 22
+	}
+
+	if !m.immediate {
+		op2, err := a.armShifted([]string{fmt.Sprintf("r%d", m.register), m.shift}[:1+btoi(m.shift != "")], false)
+		if err != nil {
+			return 0, err
+		}
+		if !m.down {
+			opcode |= 1 << 23
+		}
+		return opcode | 1<<25 | op2, nil
+	}
+
+	offset, down, err := a.immediateOffset(m)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0xFFF {
+		return 0, fmt.Errorf("offset %#x is out of range", offset)
+	}
+	if !down {
+		opcode |= 1 << 23
+	}
+	return opcode | offset, nil
+}
+
+// armAddress parses an address, turning a literal or a label into an
+// offset from PC.
+func (a *assembler) armAddress(it *item, ops []string) (*memoryOperand, error) {
+	if len(ops) == 1 && !strings.HasPrefix(ops[0], "[") {
+		target := it.address
+		if it.literal != nil {
+			target = it.literal.address
+		} else {
+			v, err := a.eval(ops[0], true)
+			if err != nil {
+				return nil, err
+			}
+			target = v
+		}
+
+		offset := target - (it.address + 8)
+		return &memoryOperand{base: 15, pre: true, immediate: true, value: fmt.Sprint(int32(offset))}, nil
+	}
+
+	return parseMemory(ops)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var blockModes = map[string]map[string][2]uint32{ // P, U
+	"ldm": {"ia": {0, 1}, "ib": {1, 1}, "da": {0, 0}, "db": {1, 0}, "fd": {0, 1}, "ed": {1, 1}, "fa": {0, 0}, "ea": {1, 0}},
+	"stm": {"ia": {0, 1}, "ib": {1, 1}, "da": {0, 0}, "db": {1, 0}, "ea": {0, 1}, "fa": {1, 1}, "ed": {0, 0}, "fd": {1, 0}},
+}
+
+func (a *assembler) armMemoryBlock(base, suffix string, ops []string) (uint32, error) {
+	if len(ops) != 2 {
+		return 0, fmt.Errorf("%s takes a base and a register list", base)
+	}
+
+	W := uint32(0)
+	Rn := strings.TrimSpace(ops[0])
+	if strings.HasSuffix(Rn, "!") {
+		W = 1
+		Rn = strings.TrimSuffix(Rn, "!")
+	}
+	r, err := register(Rn)
+	if err != nil {
+		return 0, err
+	}
+
+	list, caret, err := registerList(ops[1])
+	if err != nil {
+		return 0, err
+	}
+
+	mode := blockModes[base][suffix]
+	opcode := 1<<27 | mode[0]<<24 | mode[1]<<23 | W<<21 | r<<16 | list
+	if caret {
+		opcode |= 1 << 22
+	}
+	if base == "ldm" {
+		opcode |= 1 << 20
+	}
+	return opcode, nil
+}
+
+func psr(s string) (uint32, bool) {
+	switch strings.ToLower(s) {
+	case "cpsr":
+		return 0, true
+	case "spsr":
+		return 1, true
+	}
+	return 0, false
+}
+
+func (a *assembler) armMRS(ops []string) (uint32, error) {
+	if len(ops) != 2 {
+		return 0, fmt.Errorf("mrs takes a register and a status register")
+	}
+	Rd, err := register(ops[0])
+	if err != nil {
+		return 0, err
+	}
+	P, ok := psr(ops[1])
+	if !ok {
+		return 0, fmt.Errorf("bad status register %q", ops[1])
+	}
+	return 0x010F0000 | P<<22 | Rd<<12, nil
+}
+
+func (a *assembler) armMSR(ops []string) (uint32, error) {
+	if len(ops) != 2 {
+		return 0, fmt.Errorf("msr takes a status register and a value")
+	}
+
+	name, fields, found := strings.Cut(strings.ToLower(ops[0]), "_")
+	P, ok := psr(name)
+	if !ok {
+		return 0, fmt.Errorf("bad status register %q", ops[0])
+	}
+
+	var mask uint32
+	switch {
+	case !found || fields == "all":
+		mask = 0b1001
+	case fields == "flg":
+		mask = 0b1000
+	case fields == "ctl":
+		mask = 0b0001
+	default:
+		for _, f := range fields {
+			i := strings.IndexRune("cxsf", f)
+			if i < 0 {
+				return 0, fmt.Errorf("bad status register fields %q", fields)
+			}
+			mask |= 1 << i
+		}
+	}
+
+	opcode := 0x0120F000 | P<<22 | mask<<16
+	if isRegister(ops[1]) {
+		Rm, _ := register(ops[1])
+		return opcode | Rm, nil
+	}
+
+	value, err := a.eval(ops[1], true)
+	if err != nil {
+		return 0, err
+	}
+	imm, ok := armImmediate(value)
+	if !ok {
+		return 0, fmt.Errorf("%#x cannot be encoded as an immediate", value)
+	}
+	return 1<<25 | opcode | imm, nil
+}
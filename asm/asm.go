@@ -0,0 +1,566 @@
+// Package asm assembles ARMv4T ARM and Thumb source into machine code, so
+// tests and tools can write programs for the CPU without hand-encoding them.
+//
+// The syntax follows GNU as and the output of the disasm package:
+//
+//		.arm
+//	start:
+//		ldr	r0, =0x04000000
+//		mov	r1, #0x80
+//		strh	r1, [r0]
+//		b	start
+//		.pool
+//
+// Labels end with a colon and comments start with @, ; or //. Immediates and
+// addresses are sums and differences of numbers and symbols. The directives
+// are .arm, .thumb, .word, .hword (or .short), .byte, .align, .pool (or
+// .ltorg) and .equ (or .set). LDR rd, =value loads the value from the next
+// literal pool, which is placed at .pool or at the end of the source.
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error reports a line of source that could not be assembled.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Assemble assembles source into the bytes to load at origin. A gamepak
+// assembled at 0x08000000 can be passed straight to gba.NewMotherboard.
+func Assemble(source string, origin uint32) ([]byte, error) {
+	a := &assembler{
+		origin:  origin,
+		address: origin,
+		symbols: make(map[string]uint32),
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		a.line = i + 1
+		if err := a.parse(line); err != nil {
+			return nil, &Error{Line: a.line, Err: err}
+		}
+	}
+	a.pool()
+
+	out := make([]byte, a.address-origin)
+	for _, it := range a.items {
+		if err := a.encode(it, out[it.address-origin:]); err != nil {
+			return nil, &Error{Line: it.line, Err: err}
+		}
+	}
+
+	return out, nil
+}
+
+// MustAssemble is like Assemble but panics if the source cannot be
+// assembled. It is meant for programs written into tests.
+func MustAssemble(source string, origin uint32) []byte {
+	out, err := Assemble(source, origin)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+type mode int
+
+const (
+	armMode mode = iota
+	thumbMode
+)
+
+// item is a line of source placed at an address, waiting for every symbol
+// to be known before it is encoded.
+type item struct {
+	line     int
+	address  uint32
+	mode     mode
+	mnemonic string
+	operands []string
+
+	// data holds the values of .word, .hword and .byte, each width bytes.
+	data  []string
+	width uint32
+
+	// literal is the pool entry an LDR rd, =value loads from, and pool the
+	// entries placed by .pool.
+	literal *literal
+	pool    []*literal
+}
+
+type literal struct {
+	value   string
+	address uint32
+}
+
+type assembler struct {
+	origin  uint32
+	address uint32
+	line    int
+	mode    mode
+	symbols map[string]uint32
+	items   []*item
+
+	// literals waiting for the next pool
+	literals []*literal
+}
+
+func (a *assembler) parse(line string) error {
+	for _, comment := range []string{"@", ";", "//"} {
+		if i := strings.Index(line, comment); i >= 0 {
+			line = line[:i]
+		}
+	}
+	line = strings.TrimSpace(line)
+
+	for {
+		i := strings.Index(line, ":")
+		if i < 0 || !isSymbol(line[:i]) {
+			break
+		}
+		if err := a.define(line[:i], a.address); err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line[i+1:])
+	}
+	if line == "" {
+		return nil
+	}
+
+	mnemonic, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		mnemonic, rest = line[:i], line[i+1:]
+	}
+	mnemonic = strings.ToLower(mnemonic)
+	operands := splitOperands(rest)
+
+	if strings.HasPrefix(mnemonic, ".") {
+		return a.directive(mnemonic, operands)
+	}
+
+	it := &item{line: a.line, address: a.address, mode: a.mode, mnemonic: mnemonic, operands: operands}
+	a.items = append(a.items, it)
+
+	size := uint32(4)
+	if a.mode == thumbMode {
+		size = 2
+		if mnemonic == "bl" {
+			size = 4
+		}
+	}
+	if len(operands) == 2 && strings.HasPrefix(operands[1], "=") {
+		it.literal = a.literal(operands[1][1:])
+	}
+
+	a.address += size
+	return nil
+}
+
+func (a *assembler) directive(name string, operands []string) error {
+	switch name {
+	case ".arm", ".code32":
+		a.mode = armMode
+	case ".thumb", ".code16":
+		a.mode = thumbMode
+	case ".word", ".hword", ".short", ".byte":
+		width := map[string]uint32{".word": 4, ".hword": 2, ".short": 2, ".byte": 1}[name]
+		a.items = append(a.items, &item{line: a.line, address: a.address, data: operands, width: width})
+		a.address += width * uint32(len(operands))
+	case ".align":
+		power := uint32(2)
+		if len(operands) > 0 {
+			v, err := a.eval(operands[0], false)
+			if err != nil {
+				return err
+			}
+			power = v
+		}
+		a.align(1 << power)
+	case ".pool", ".ltorg":
+		a.pool()
+	case ".equ", ".set":
+		if len(operands) != 2 {
+			return fmt.Errorf("%s takes a name and a value", name)
+		}
+		v, err := a.eval(operands[1], false)
+		if err != nil {
+			return err
+		}
+		return a.define(operands[0], v)
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+func (a *assembler) define(name string, value uint32) error {
+	if !isSymbol(name) {
+		return fmt.Errorf("bad symbol name %q", name)
+	}
+	if _, ok := a.symbols[name]; ok {
+		return fmt.Errorf("%s defined twice", name)
+	}
+	a.symbols[name] = value
+	return nil
+}
+
+// align pads with zeros up to a multiple of size.
+func (a *assembler) align(size uint32) {
+	a.address = (a.address + size - 1) &^ (size - 1)
+}
+
+// literal adds a value to the next pool, sharing an entry with the same
+// value if there is one.
+func (a *assembler) literal(value string) *literal {
+	for _, l := range a.literals {
+		if l.value == value {
+			return l
+		}
+	}
+
+	l := &literal{value: value}
+	a.literals = append(a.literals, l)
+	return l
+}
+
+// pool places the waiting literals.
+func (a *assembler) pool() {
+	if len(a.literals) == 0 {
+		return
+	}
+
+	a.align(4)
+	it := &item{line: a.line, address: a.address, pool: a.literals}
+	for _, l := range a.literals {
+		l.address = a.address
+		a.address += 4
+	}
+	a.items = append(a.items, it)
+	a.literals = nil
+}
+
+func (a *assembler) encode(it *item, out []byte) error {
+	switch {
+	case it.data != nil:
+		for i, d := range it.data {
+			v, err := a.eval(d, true)
+			if err != nil {
+				return err
+			}
+			put(out[uint32(i)*it.width:], v, it.width)
+		}
+	case it.pool != nil:
+		for i, l := range it.pool {
+			v, err := a.eval(l.value, true)
+			if err != nil {
+				return err
+			}
+			put(out[i*4:], v, 4)
+		}
+	case it.mode == armMode:
+		opcode, err := a.arm(it)
+		if err != nil {
+			return err
+		}
+		put(out, opcode, 4)
+	case it.mode == thumbMode:
+		opcodes, err := a.thumb(it)
+		if err != nil {
+			return err
+		}
+		for i, opcode := range opcodes {
+			put(out[i*2:], uint32(opcode), 2)
+		}
+	}
+	return nil
+}
+
+func put(out []byte, value, width uint32) {
+	for i := range width {
+		out[i] = byte(value >> (8 * i))
+	}
+}
+
+// eval evaluates a sum of numbers and symbols, with an optional leading #.
+// Symbols defined later in the source are only known once every line has
+// been placed.
+func (a *assembler) eval(s string, late bool) (uint32, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if s == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	var total uint32
+	sign := uint32(1)
+	for s != "" {
+		switch s[0] {
+		case '+':
+			s = strings.TrimSpace(s[1:])
+			continue
+		case '-':
+			sign = -sign
+			s = strings.TrimSpace(s[1:])
+			continue
+		}
+
+		end := strings.IndexAny(s, "+-")
+		if end < 0 {
+			end = len(s)
+		}
+		term := strings.TrimSpace(s[:end])
+		s = strings.TrimSpace(s[end:])
+
+		v, err := a.term(term, late)
+		if err != nil {
+			return 0, err
+		}
+		total += sign * v
+		sign = 1
+	}
+
+	return total, nil
+}
+
+func (a *assembler) term(term string, late bool) (uint32, error) {
+	if term == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	if term[0] >= '0' && term[0] <= '9' {
+		v, err := strconv.ParseUint(term, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad number %q", term)
+		}
+		return uint32(v), nil
+	}
+
+	if v, ok := a.symbols[term]; ok {
+		return v, nil
+	}
+	if !isSymbol(term) {
+		return 0, fmt.Errorf("bad value %q", term)
+	}
+	if !late {
+		return 0, fmt.Errorf("%s is not defined yet", term)
+	}
+	return 0, fmt.Errorf("undefined symbol %s", term)
+}
+
+func isSymbol(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitOperands splits operands on the commas outside brackets and braces.
+func splitOperands(s string) []string {
+	var operands []string
+	depth := 0
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				operands = append(operands, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(operands) > 0 {
+		operands = append(operands, last)
+	}
+	return operands
+}
+
+var registerNames = map[string]uint32{
+	"sp": 13, "lr": 14, "pc": 15,
+	"sb": 9, "sl": 10, "fp": 11, "ip": 12,
+}
+
+func isRegister(s string) bool {
+	_, err := register(s)
+	return err == nil
+}
+
+func register(s string) (uint32, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if r, ok := registerNames[s]; ok {
+		return r, nil
+	}
+	if strings.HasPrefix(s, "r") {
+		if r, err := strconv.ParseUint(s[1:], 10, 8); err == nil && r < 16 {
+			return uint32(r), nil
+		}
+	}
+	return 0, fmt.Errorf("bad register %q", s)
+}
+
+// lowRegister parses one of the registers Thumb instructions can address
+// in three bits.
+func lowRegister(s string) (uint32, error) {
+	r, err := register(s)
+	if err == nil && r > 7 {
+		err = fmt.Errorf("%s is not a low register", s)
+	}
+	return r, err
+}
+
+// registerList parses a list like {r0-r3, lr}, reporting whether it ends
+// with ^.
+func registerList(s string) (list uint32, caret bool, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "^") {
+		caret = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "^"))
+	}
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return 0, false, fmt.Errorf("bad register list %q", s)
+	}
+
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return 0, caret, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		first, last, ok := strings.Cut(part, "-")
+		lo, err := register(first)
+		if err != nil {
+			return 0, false, err
+		}
+		hi := lo
+		if ok {
+			if hi, err = register(last); err != nil {
+				return 0, false, err
+			}
+		}
+		if hi < lo {
+			return 0, false, fmt.Errorf("bad register range %q", part)
+		}
+		for r := lo; r <= hi; r++ {
+			list |= 1 << r
+		}
+	}
+	return list, caret, nil
+}
+
+// memoryOperand is a parsed [base, offset] address with its writeback and
+// any post-indexed offset folded in.
+type memoryOperand struct {
+	base      uint32
+	pre       bool
+	writeback bool
+
+	// offset is either an immediate or a register, possibly negated and,
+	// in ARM code, shifted.
+	immediate bool
+	value     string
+	register  uint32
+	down      bool
+	shift     string
+}
+
+func parseMemory(operands []string) (*memoryOperand, error) {
+	if len(operands) == 0 || !strings.HasPrefix(operands[0], "[") {
+		return nil, fmt.Errorf("missing address")
+	}
+
+	m := &memoryOperand{immediate: true, value: "0"}
+
+	address := strings.TrimSpace(operands[0])
+	if strings.HasSuffix(address, "!") {
+		m.writeback = true
+		address = strings.TrimSpace(strings.TrimSuffix(address, "!"))
+	}
+	if !strings.HasSuffix(address, "]") {
+		return nil, fmt.Errorf("bad address %q", operands[0])
+	}
+	inside := splitOperands(address[1 : len(address)-1])
+
+	base, err := register(inside[0])
+	if err != nil {
+		return nil, err
+	}
+	m.base = base
+
+	offset := inside[1:]
+	switch {
+	case len(operands) > 1 && len(offset) == 0 && !m.writeback:
+		offset = operands[1:]
+	case len(operands) > 1:
+		return nil, fmt.Errorf("bad address")
+	default:
+		m.pre = true
+	}
+
+	switch len(offset) {
+	case 0:
+		return m, nil
+	case 1, 2:
+	default:
+		return nil, fmt.Errorf("bad address offset")
+	}
+
+	first := strings.TrimSpace(offset[0])
+	if strings.HasPrefix(first, "#") {
+		if len(offset) > 1 {
+			return nil, fmt.Errorf("immediate offsets cannot be shifted")
+		}
+		m.value = first
+		return m, nil
+	}
+
+	if strings.HasPrefix(first, "-") {
+		m.down = true
+		first = first[1:]
+	}
+	first = strings.TrimPrefix(first, "+")
+	r, err := register(first)
+	if err != nil {
+		return nil, err
+	}
+	m.immediate = false
+	m.register = r
+	if len(offset) > 1 {
+		m.shift = offset[1]
+	}
+	return m, nil
+}
+
+// immediateOffset evaluates an immediate offset, returning its magnitude
+// and whether it counts down.
+func (a *assembler) immediateOffset(m *memoryOperand) (value uint32, down bool, err error) {
+	v, err := a.eval(m.value, true)
+	if err != nil {
+		return 0, false, err
+	}
+	if int32(v) < 0 {
+		return -v, true, nil
+	}
+	return v, false, nil
+}
@@ -0,0 +1,201 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dbut2/sapphire/disasm"
+)
+
+// TestDisassembly assembles what the disassembler makes of each opcode and
+// expects the opcode back.
+func TestDisassembly(t *testing.T) {
+	const addr = 0x08000100
+
+	arm := []uint32{
+		0xE0810002, // add r0, r1, r2
+		0xE2510001, // subs r0, r1, #1
+		0xE3A00301, // mov r0, #0x4000000
+		0xE1A00102, // mov r0, r2, lsl #2
+		0xE1B00231, // movs r0, r1, lsr r2
+		0xE1A00061, // mov r0, r1, rrx
+		0xE1A00041, // mov r0, r1, asr #32
+		0xE3500000, // cmp r0, #0
+		0x13C000FF, // bicne r0, r0, #0xff
+		0xE28F0010, // add r0, pc, #0x10
+		0xE5910004, // ldr r0, [r1, #4]
+		0xE5310004, // ldr r0, [r1, #-4]!
+		0xE4D10001, // ldrb r0, [r1], #1
+		0xE7910102, // ldr r0, [r1, r2, lsl #2]
+		0xE7010002, // str r0, [r1, -r2]
+		0xE4B10004, // ldrt r0, [r1], #4
+		0xE1D100B2, // ldrh r0, [r1, #2]
+		0xE19100D2, // ldrsb r0, [r1, r2]
+		0xE05100F2, // ldrsh r0, [r1], #-2
+		0xE59F0008, // ldr r0, [pc, #8]
+		0xE92D4010, // push {r4, lr}
+		0xE8BD8010, // pop {r4, pc}
+		0xE8B10006, // ldmia r1!, {r1, r2}
+		0xE9400003, // stmdb r0, {r0, r1}^
+		0xEA000002, // b 0x08000110
+		0xEBFFFFFE, // bl 0x08000100
+		0xE12FFF1E, // bx lr
+		0xE1020091, // swp r0, r1, [r2]
+		0xE0000291, // mul r0, r1, r2
+		0xE0303291, // mlas r0, r1, r2, r3
+		0xE0810392, // umull r0, r1, r2, r3
+		0xE0E10392, // smlal r0, r1, r2, r3
+		0xE10F0000, // mrs r0, cpsr
+		0xE169F001, // msr spsr_cf, r1
+		0xE328F20F, // msr cpsr_f, #0xf0000000
+		0xEF000005, // svc 5
+	}
+	for _, opcode := range arm {
+		text := disasm.Arm(opcode, addr)
+		out, err := Assemble(".arm\n"+text, addr)
+		if err != nil {
+			t.Errorf("%08X %q: %v", opcode, text, err)
+			continue
+		}
+		if got := binary.LittleEndian.Uint32(out); got != opcode {
+			t.Errorf("%q = %08X, want %08X", text, got, opcode)
+		}
+	}
+
+	thumb := []uint16{
+		0x0088, // lsls r0, r1, #2
+		0x0FC8, // lsrs r0, r1, #31
+		0x1888, // adds r0, r1, r2
+		0x1E48, // subs r0, r1, #1
+		0x2080, // movs r0, #0x80
+		0x2A10, // cmp r2, #0x10
+		0x3801, // subs r0, #1
+		0x4008, // ands r0, r1
+		0x4348, // muls r0, r1
+		0x4248, // negs r0, r1
+		0x4468, // add r0, sp
+		0x4545, // cmp r5, r8
+		0x46C0, // mov r8, r8
+		0x4770, // bx lr
+		0x4802, // ldr r0, [pc, #8]
+		0x5088, // str r0, [r1, r2]
+		0x5E88, // ldrsh r0, [r1, r2]
+		0x6848, // ldr r0, [r1, #4]
+		0x7848, // ldrb r0, [r1, #1]
+		0x8848, // ldrh r0, [r1, #2]
+		0x9801, // ldr r0, [sp, #4]
+		0xA001, // add r0, pc, #4
+		0xA901, // add r1, sp, #4
+		0xB082, // sub sp, #8
+		0xB510, // push {r4, lr}
+		0xBD10, // pop {r4, pc}
+		0xC906, // ldmia r1!, {r1, r2}
+		0xD0FE, // beq 0x08000100
+		0xE7FE, // b 0x08000100
+		0xDF05, // svc 5
+	}
+	for _, opcode := range thumb {
+		text := disasm.Thumb(opcode, addr)
+		out, err := Assemble(".thumb\n"+text, addr)
+		if err != nil {
+			t.Errorf("%04X %q: %v", opcode, text, err)
+			continue
+		}
+		if got := binary.LittleEndian.Uint16(out); got != opcode {
+			t.Errorf("%q = %04X, want %04X", text, got, opcode)
+		}
+	}
+
+	bl := []uint16{0xF7FF, 0xFFFE}
+	text := disasm.ThumbBL(bl[0], bl[1], addr)
+	out, err := Assemble(".thumb\n"+text, addr)
+	if err != nil {
+		t.Fatalf("%q: %v", text, err)
+	}
+	if got := []uint16{binary.LittleEndian.Uint16(out), binary.LittleEndian.Uint16(out[2:])}; got[0] != bl[0] || got[1] != bl[1] {
+		t.Errorf("%q = %04X, want %04X", text, got, bl)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	source := `
+	.equ	IO, 0x04000000
+start:	ldr	r0, =IO		@ literal
+	ldr	r1, =0x12345678
+	ldr	r2, =IO
+	adr	r3, thumb + 1
+	bx	r3
+	.pool
+
+data:	.word	start, data + 4
+	.hword	0xBEEF
+	.byte	1, 2
+	.align
+
+	.thumb
+thumb:	ldr	r0, =0xCAFE
+	bl	start
+	b	thumb
+`
+	out, err := Assemble(source, 0x08000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words := make([]uint32, len(out)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(out[i*4:])
+	}
+	want := []uint32{
+		0xE59F000C, // ldr r0, [pc, #12]
+		0xE59F100C, // ldr r1, [pc, #12]
+		0xE59F2004, // ldr r2, [pc, #4]
+		0xE28F3015, // add r3, pc, #0x15
+		0xE12FFF13, // bx r3
+		0x04000000,
+		0x12345678,
+		0x08000000, // data: start
+		0x08000020, // data + 4
+		0x0201BEEF,
+		0xF7FF4801, // ldr r0, [pc, #4]; bl start
+		0xE7FBFFE9, // bl start; b thumb
+		0x0000CAFE,
+	}
+	if len(words) != len(want) {
+		t.Fatalf("assembled %d words, want %d:\n%08X", len(words), len(want), words)
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("word %d = %08X, want %08X", i, words[i], want[i])
+		}
+	}
+}
+
+func TestAssembleError(t *testing.T) {
+	tests := []struct {
+		source string
+		line   int
+		want   string
+	}{
+		{"mov r0, #1\nfoo r0", 2, "unknown instruction foo"},
+		{"b nowhere", 1, "undefined symbol nowhere"},
+		{"mov r0, #0x101", 1, "cannot be encoded"},
+		{".thumb\n\nmov r8, #1", 3, "low register"},
+		{"a:\na:", 2, "defined twice"},
+		{".thumb\nb far\n.align 12\nfar:", 2, "out of range"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.source, 0)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Assemble(%q) = %v, want an *Error", tt.source, err)
+			continue
+		}
+		if e.Line != tt.line || !strings.Contains(e.Err.Error(), tt.want) {
+			t.Errorf("Assemble(%q) = %v, want line %d: %s", tt.source, err, tt.line, tt.want)
+		}
+	}
+}
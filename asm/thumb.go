@@ -0,0 +1,461 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+var thumbALUOpcodes = map[string]uint32{
+	"and": 0x0, "eor": 0x1, "lsl": 0x2, "lsr": 0x3, "asr": 0x4, "adc": 0x5, "sbc": 0x6, "ror": 0x7,
+	"tst": 0x8, "neg": 0x9, "cmp": 0xA, "cmn": 0xB, "orr": 0xC, "mul": 0xD, "bic": 0xE, "mvn": 0xF,
+}
+
+var thumbShiftOpcodes = map[string]uint32{"lsl": 0, "lsr": 1, "asr": 2}
+
+// thumbMemoryOpcodes are the register offset and immediate offset forms of
+// each load and store, with the scale of the immediate.
+var thumbMemoryOpcodes = map[string]struct {
+	register  uint16
+	immediate uint16
+	scale     uint32
+}{
+	"str":   {0x5000, 0x6000, 4},
+	"ldr":   {0x5800, 0x6800, 4},
+	"strb":  {0x5400, 0x7000, 1},
+	"ldrb":  {0x5C00, 0x7800, 1},
+	"strh":  {0x5200, 0x8000, 2},
+	"ldrh":  {0x5A00, 0x8800, 2},
+	"ldrsb": {0x5600, 0, 0},
+	"ldrsh": {0x5E00, 0, 0},
+}
+
+// thumbMnemonic removes the S suffix Thumb instructions are written with
+// in unified syntax; every data processing instruction on low registers
+// sets the flags either way.
+func thumbMnemonic(mnemonic string) string {
+	switch mnemonic {
+	case "ldsb", "ldsh":
+		return "ldr" + mnemonic[2:]
+	case "stm", "ldm":
+		return mnemonic + "ia"
+	case "swi":
+		return "svc"
+	}
+	if base := strings.TrimSuffix(mnemonic, "s"); len(mnemonic) > 3 {
+		if _, ok := thumbALUOpcodes[base]; ok {
+			return base
+		}
+		switch base {
+		case "add", "sub", "mov":
+			return base
+		}
+	}
+	return mnemonic
+}
+
+func (a *assembler) thumb(it *item) ([]uint16, error) {
+	mnemonic := thumbMnemonic(it.mnemonic)
+	ops := it.operands
+
+	switch mnemonic {
+	case "nop":
+		return []uint16{0x46C0}, nil
+	case "add", "sub":
+		return a.thumbAddSub(it, mnemonic, ops)
+	case "adr":
+		if len(ops) != 2 {
+			return nil, fmt.Errorf("adr takes a register and an address")
+		}
+		return a.thumbAddSub(it, "add", []string{ops[0], "pc", ops[1]})
+	case "mov":
+		return a.thumbMov(ops)
+	case "cmp":
+		return a.thumbCmp(ops)
+	case "lsl", "lsr", "asr":
+		if len(ops) == 3 {
+			return a.thumbShift(mnemonic, ops)
+		}
+	case "ldr", "str", "ldrb", "strb", "ldrh", "strh", "ldrsb", "ldrsh":
+		return a.thumbMemory(it, mnemonic, ops)
+	case "push", "pop":
+		return a.thumbPushPop(mnemonic, ops)
+	case "stmia", "ldmia":
+		return a.thumbMemoryBlock(mnemonic, ops)
+	case "bx":
+		if len(ops) != 1 {
+			return nil, fmt.Errorf("bx takes a register")
+		}
+		Rs, err := register(ops[0])
+		return []uint16{0x4700 | uint16(Rs)<<3}, err
+	case "svc":
+		if len(ops) != 1 {
+			return nil, fmt.Errorf("svc takes a comment")
+		}
+		comment, err := a.eval(ops[0], true)
+		if err == nil && comment > 0xFF {
+			err = fmt.Errorf("comment %#x does not fit in 8 bits", comment)
+		}
+		return []uint16{0xDF00 | uint16(comment)}, err
+	case "bl":
+		return a.thumbBranchLink(it, ops)
+	}
+
+	if opcode, ok := thumbALUOpcodes[mnemonic]; ok {
+		return a.thumbALU(opcode, ops)
+	}
+	if strings.HasPrefix(mnemonic, "b") {
+		if cond, ok := conditions[mnemonic[1:]]; ok {
+			return a.thumbBranch(it, cond, ops)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown instruction %s", it.mnemonic)
+}
+
+func (a *assembler) thumbALU(opcode uint32, ops []string) ([]uint16, error) {
+	// MUL may name its destination again as the last operand
+	if opcode == 0xD && len(ops) == 3 && strings.EqualFold(ops[0], ops[2]) {
+		ops = ops[:2]
+	}
+	if len(ops) != 2 {
+		return nil, fmt.Errorf("expected two low registers")
+	}
+
+	Rd, err := lowRegister(ops[0])
+	if err != nil {
+		return nil, err
+	}
+	Rs, err := lowRegister(ops[1])
+	if err != nil {
+		return nil, err
+	}
+	return []uint16{uint16(0x4000 | opcode<<6 | Rs<<3 | Rd)}, nil
+}
+
+func (a *assembler) thumbShift(mnemonic string, ops []string) ([]uint16, error) {
+	Rd, err := lowRegister(ops[0])
+	if err != nil {
+		return nil, err
+	}
+	Rs, err := lowRegister(ops[1])
+	if err != nil {
+		return nil, err
+	}
+	amount, err := a.eval(ops[2], true)
+	if err != nil {
+		return nil, err
+	}
+
+	op := thumbShiftOpcodes[mnemonic]
+	switch {
+	case amount <= 31 && (op == 0 || amount > 0):
+	case amount == 32 && op != 0:
+		amount = 0
+	default:
+		return nil, fmt.Errorf("bad shift amount %d", amount)
+	}
+	return []uint16{uint16(op<<11 | amount<<6 | Rs<<3 | Rd)}, nil
+}
+
+func (a *assembler) thumbAddSub(it *item, mnemonic string, ops []string) ([]uint16, error) {
+	sub := uint32(0)
+	if mnemonic == "sub" {
+		sub = 1
+	}
+
+	// ADD rd, #imm is ADD rd, rd, #imm
+	if len(ops) == 2 && !isRegister(ops[1]) {
+		ops = []string{ops[0], ops[0], ops[1]}
+	}
+
+	switch len(ops) {
+	case 2:
+		Rd, err := register(ops[0])
+		if err != nil {
+			return nil, err
+		}
+		Rs, err := register(ops[1])
+		if err != nil {
+			return nil, err
+		}
+		if Rd > 7 || Rs > 7 {
+			if sub == 1 {
+				return nil, fmt.Errorf("sub cannot take high registers")
+			}
+			return []uint16{uint16(0x4400 | Rd>>3<<7 | Rs>>3<<6 | Rs&7<<3 | Rd&7)}, nil
+		}
+		return []uint16{uint16(0x1800 | sub<<9 | Rs<<6 | Rd<<3 | Rd)}, nil
+	case 3:
+	default:
+		return nil, fmt.Errorf("%s takes two or three operands", mnemonic)
+	}
+
+	Rd, err := register(ops[0])
+	if err != nil {
+		return nil, err
+	}
+	Rs, err := register(ops[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if isRegister(ops[2]) {
+		Rn, err := lowRegister(ops[2])
+		if err != nil {
+			return nil, err
+		}
+		if Rd > 7 || Rs > 7 {
+			return nil, fmt.Errorf("%s takes low registers", mnemonic)
+		}
+		return []uint16{uint16(0x1800 | sub<<9 | Rn<<6 | Rs<<3 | Rd)}, nil
+	}
+
+	value, err := a.eval(ops[2], true)
+	if err != nil {
+		return nil, err
+	}
+	if int32(value) < 0 {
+		value, sub = -value, sub^1
+	}
+
+	switch {
+	case Rd == 13 && Rs == 13:
+		if value%4 != 0 || value > 508 {
+			return nil, fmt.Errorf("offset %#x is out of range", value)
+		}
+		return []uint16{uint16(0xB000 | sub<<7 | value/4)}, nil
+	case Rs == 13 || Rs == 15:
+		if Rs == 15 && !strings.HasPrefix(strings.TrimSpace(ops[2]), "#") {
+			// a label, as taken by ADR
+			value = value - (it.address+4)&^2
+		}
+		if sub == 1 || Rd > 7 || value%4 != 0 || value > 1020 {
+			return nil, fmt.Errorf("offset %#x is out of range", value)
+		}
+		SP := uint32(0)
+		if Rs == 13 {
+			SP = 1
+		}
+		return []uint16{uint16(0xA000 | SP<<11 | Rd<<8 | value/4)}, nil
+	case Rd > 7 || Rs > 7:
+		return nil, fmt.Errorf("%s takes low registers", mnemonic)
+	case value <= 7 && (Rd != Rs || value == 0):
+		return []uint16{uint16(0x1C00 | sub<<9 | value<<6 | Rs<<3 | Rd)}, nil
+	case Rd == Rs && value <= 0xFF:
+		return []uint16{uint16(0x3000 | sub<<11 | Rd<<8 | value)}, nil
+	}
+	return nil, fmt.Errorf("%#x is out of range", value)
+}
+
+func (a *assembler) thumbMov(ops []string) ([]uint16, error) {
+	if len(ops) != 2 {
+		return nil, fmt.Errorf("mov takes two operands")
+	}
+	Rd, err := register(ops[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRegister(ops[1]) {
+		value, err := a.eval(ops[1], true)
+		if err != nil {
+			return nil, err
+		}
+		if Rd > 7 || value > 0xFF {
+			return nil, fmt.Errorf("mov takes a low register and an 8-bit immediate")
+		}
+		return []uint16{uint16(0x2000 | Rd<<8 | value)}, nil
+	}
+
+	Rs, _ := register(ops[1])
+	if Rd > 7 || Rs > 7 {
+		return []uint16{uint16(0x4600 | Rd>>3<<7 | Rs>>3<<6 | Rs&7<<3 | Rd&7)}, nil
+	}
+	// a move between low registers is LSL #0
+	return []uint16{uint16(Rs<<3 | Rd)}, nil
+}
+
+func (a *assembler) thumbCmp(ops []string) ([]uint16, error) {
+	if len(ops) != 2 {
+		return nil, fmt.Errorf("cmp takes two operands")
+	}
+	Rd, err := register(ops[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRegister(ops[1]) {
+		value, err := a.eval(ops[1], true)
+		if err != nil {
+			return nil, err
+		}
+		if Rd > 7 || value > 0xFF {
+			return nil, fmt.Errorf("cmp takes a low register and an 8-bit immediate")
+		}
+		return []uint16{uint16(0x2800 | Rd<<8 | value)}, nil
+	}
+
+	Rs, _ := register(ops[1])
+	if Rd > 7 || Rs > 7 {
+		return []uint16{uint16(0x4500 | Rd>>3<<7 | Rs>>3<<6 | Rs&7<<3 | Rd&7)}, nil
+	}
+	return []uint16{uint16(0x4280 | Rs<<3 | Rd)}, nil
+}
+
+func (a *assembler) thumbMemory(it *item, mnemonic string, ops []string) ([]uint16, error) {
+	if len(ops) < 2 {
+		return nil, fmt.Errorf("%s takes a register and an address", mnemonic)
+	}
+	Rd, err := lowRegister(ops[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// a literal or a label is loaded relative to PC
+	if mnemonic == "ldr" && len(ops) == 2 && !strings.HasPrefix(ops[1], "[") {
+		target := uint32(0)
+		if it.literal != nil {
+			target = it.literal.address
+		} else if target, err = a.eval(ops[1], true); err != nil {
+			return nil, err
+		}
+
+		offset := target - (it.address+4)&^2
+		if offset%4 != 0 || offset > 1020 {
+			return nil, fmt.Errorf("%#x is out of range of ldr", target)
+		}
+		return []uint16{uint16(0x4800 | Rd<<8 | offset/4)}, nil
+	}
+
+	m, err := parseMemory(ops[1:])
+	if err != nil {
+		return nil, err
+	}
+	if !m.pre || m.writeback || m.down || m.shift != "" {
+		return nil, fmt.Errorf("bad address for %s", mnemonic)
+	}
+
+	op := thumbMemoryOpcodes[mnemonic]
+	if !m.immediate {
+		if m.base > 7 || m.register > 7 {
+			return nil, fmt.Errorf("%s takes low registers", mnemonic)
+		}
+		return []uint16{op.register | uint16(m.register<<6|m.base<<3|Rd)}, nil
+	}
+
+	offset, err := a.eval(m.value, true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case m.base == 13 && (mnemonic == "ldr" || mnemonic == "str"):
+		if offset%4 != 0 || offset > 1020 {
+			return nil, fmt.Errorf("offset %#x is out of range", offset)
+		}
+		L := uint32(0)
+		if mnemonic == "ldr" {
+			L = 1
+		}
+		return []uint16{uint16(0x9000 | L<<11 | Rd<<8 | offset/4)}, nil
+	case m.base == 15 && mnemonic == "ldr":
+		if offset%4 != 0 || offset > 1020 {
+			return nil, fmt.Errorf("offset %#x is out of range", offset)
+		}
+		return []uint16{uint16(0x4800 | Rd<<8 | offset/4)}, nil
+	case op.scale == 0:
+		return nil, fmt.Errorf("%s takes a register offset", mnemonic)
+	case m.base > 7:
+		return nil, fmt.Errorf("%s takes a low base register", mnemonic)
+	case offset%op.scale != 0 || offset/op.scale > 31:
+		return nil, fmt.Errorf("offset %#x is out of range", offset)
+	}
+	return []uint16{op.immediate | uint16(offset/op.scale<<6|m.base<<3|Rd)}, nil
+}
+
+func (a *assembler) thumbPushPop(mnemonic string, ops []string) ([]uint16, error) {
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("%s takes a register list", mnemonic)
+	}
+	list, caret, err := registerList(ops[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opcode, extra := uint32(0xB400), uint32(1<<14)
+	if mnemonic == "pop" {
+		opcode, extra = 0xBC00, 1<<15
+	}
+	if caret || list&^(0xFF|extra) != 0 {
+		return nil, fmt.Errorf("%s takes low registers and %s", mnemonic, map[string]string{"push": "lr", "pop": "pc"}[mnemonic])
+	}
+	if list&extra != 0 {
+		opcode |= 1 << 8
+	}
+	return []uint16{uint16(opcode | list&0xFF)}, nil
+}
+
+func (a *assembler) thumbMemoryBlock(mnemonic string, ops []string) ([]uint16, error) {
+	if len(ops) != 2 || !strings.HasSuffix(strings.TrimSpace(ops[0]), "!") {
+		return nil, fmt.Errorf("%s takes a base with writeback and a register list", mnemonic)
+	}
+	Rb, err := lowRegister(strings.TrimSuffix(strings.TrimSpace(ops[0]), "!"))
+	if err != nil {
+		return nil, err
+	}
+	list, caret, err := registerList(ops[1])
+	if err != nil {
+		return nil, err
+	}
+	if caret || list > 0xFF {
+		return nil, fmt.Errorf("%s takes low registers", mnemonic)
+	}
+
+	L := uint32(0)
+	if mnemonic == "ldmia" {
+		L = 1
+	}
+	return []uint16{uint16(0xC000 | L<<11 | Rb<<8 | list)}, nil
+}
+
+func (a *assembler) thumbBranch(it *item, cond uint32, ops []string) ([]uint16, error) {
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("branch takes a target")
+	}
+	target, err := a.eval(ops[0], true)
+	if err != nil {
+		return nil, err
+	}
+	offset := int32(target - (it.address + 4))
+
+	if cond == 0xE {
+		if offset%2 != 0 || offset < -2048 || offset >= 2048 {
+			return nil, fmt.Errorf("%#x is out of range of b", target)
+		}
+		return []uint16{0xE000 | uint16(offset>>1)&0x7FF}, nil
+	}
+
+	if offset%2 != 0 || offset < -256 || offset >= 256 {
+		return nil, fmt.Errorf("%#x is out of range of %s", target, it.mnemonic)
+	}
+	return []uint16{uint16(0xD000|cond<<8) | uint16(offset>>1)&0xFF}, nil
+}
+
+func (a *assembler) thumbBranchLink(it *item, ops []string) ([]uint16, error) {
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("bl takes a target")
+	}
+	target, err := a.eval(ops[0], true)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int32(target - (it.address + 4))
+	if offset%2 != 0 || offset < -1<<22 || offset >= 1<<22 {
+		return nil, fmt.Errorf("%#x is out of range of bl", target)
+	}
+	return []uint16{
+		0xF000 | uint16(offset>>12)&0x7FF,
+		0xF800 | uint16(offset>>1)&0x7FF,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/dbut2/dialog"
+
+	"github.com/dbut2/sapphire/disasm"
+	"github.com/dbut2/sapphire/gba"
+)
+
+// crash reports an error that stopped emulation, logging it and offering to
+// save a crash report.
+func crash(err error) {
+	log.Printf("emulation stopped: %v", err)
+
+	var fault *gba.Fault
+	if !errors.As(err, &fault) {
+		dialog.Message("%v", err).Title("Emulation stopped").Error()
+		return
+	}
+
+	if !dialog.Message("%v\n\nSave a crash report?", err).Title("Emulation stopped").YesNo() {
+		return
+	}
+
+	filename, err := dialog.File().Title("Save crash report").Filter("Text file", "txt").SetStartFile("sapphire-crash.txt").Save()
+	if errors.Is(err, dialog.ErrCancelled) {
+		return
+	}
+	if err == nil {
+		err = saveCrashReport(filename, fault)
+	}
+	if err != nil {
+		log.Printf("saving crash report: %v", err)
+		dialog.Message("%v", err).Title("Crash report not saved").Error()
+	}
+}
+
+func saveCrashReport(filename string, fault *gba.Fault) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCrashReport(f, fault); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeCrashReport describes a fault, the registers at the time and the
+// instructions leading up to it.
+func writeCrashReport(w io.Writer, fault *gba.Fault) error {
+	report := fmt.Sprintf("%v\n\ncpsr=%08X\n", fault, fault.CPSR)
+	for i, r := range fault.R {
+		sep := " "
+		if i%4 == 3 {
+			sep = "\n"
+		}
+		report += fmt.Sprintf("r%-2d=%08X%s", i, r, sep)
+	}
+
+	report += "\nlast executed:\n"
+	for _, t := range fault.Trace {
+		if t.Thumb {
+			report += fmt.Sprintf("  %08x:\t%04x\t\t%s\n", t.PC, t.Opcode, disasm.Thumb(uint16(t.Opcode), t.PC))
+		} else {
+			report += fmt.Sprintf("  %08x:\t%08x\t%s\n", t.PC, t.Opcode, disasm.Arm(t.Opcode, t.PC))
+		}
+	}
+
+	var p *gba.PanicError
+	if errors.As(fault, &p) {
+		report += fmt.Sprintf("\n%s", p.Stack)
+	}
+
+	_, err := io.WriteString(w, report)
+	return err
+}
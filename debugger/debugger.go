@@ -18,5 +18,7 @@ func main() {
 	e.Hooks.RegisterHook(gba.PreStepCPUEmuHook, func(emulator *gba.Emulator) {
 		// Do something before CPU step
 	})
-	e.Boot()
+	if err := e.Boot(); err != nil {
+		panic(err.Error())
+	}
 }
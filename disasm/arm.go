@@ -0,0 +1,346 @@
+package disasm
+
+import (
+	"fmt"
+
+	"github.com/dbut2/sapphire/gba"
+)
+
+var aluMnemonics = [16]string{"and", "eor", "sub", "rsb", "add", "adc", "sbc", "rsc", "tst", "teq", "cmp", "cmn", "orr", "mov", "bic", "mvn"}
+
+var shiftMnemonics = [4]string{"lsl", "lsr", "asr", "ror"}
+
+// Arm disassembles an ARM opcode found at addr, which PC-relative operands
+// are resolved against.
+func Arm(opcode, addr uint32) string {
+	cond := conditions[bits(opcode, 28, 4)]
+
+	switch gba.DecodeArm(opcode) {
+	case gba.ArmClassBranchX:
+		return armBranchX(opcode, cond)
+	case gba.ArmClassMultiply:
+		return armMultiply(opcode, cond)
+	case gba.ArmClassMultiplyLong:
+		return armMultiplyLong(opcode, cond)
+	case gba.ArmClassSwap:
+		return armSwap(opcode, cond)
+	case gba.ArmClassMemoryHalf:
+		return armMemoryHalf(opcode, addr, cond)
+	case gba.ArmClassPSR:
+		return armPSR(opcode, cond)
+	case gba.ArmClassSWI:
+		return op("svc"+cond, fmt.Sprintf("0x%08x", bits(opcode, 0, 24)))
+	case gba.ArmClassMemoryBlock:
+		return armMemoryBlock(opcode, cond)
+	case gba.ArmClassBranch:
+		return armBranch(opcode, addr, cond)
+	case gba.ArmClassMemory:
+		return armMemory(opcode, addr, cond)
+	case gba.ArmClassALU:
+		return armALU(opcode, addr, cond)
+	default:
+		return op(".word", fmt.Sprintf("0x%08x", opcode))
+	}
+}
+
+func armALU(opcode, addr uint32, cond string) string {
+	Opcode := bits(opcode, 21, 4)
+	S := bits(opcode, 20, 1)
+	Rn := bits(opcode, 16, 4)
+	Rd := bits(opcode, 12, 4)
+
+	mnemonic := aluMnemonics[Opcode]
+	op2 := armOperand(opcode)
+
+	switch Opcode {
+	case 0b1000, 0b1001, 0b1010, 0b1011: // TST, TEQ, CMP, CMN
+		return op(mnemonic+cond, reg(Rn), op2)
+	case 0b1101, 0b1111: // MOV, MVN
+		if S == 1 {
+			mnemonic += "s"
+		}
+		return op(mnemonic+cond, reg(Rd), op2)
+	}
+
+	if S == 1 {
+		mnemonic += "s"
+	}
+
+	text := op(mnemonic+cond, reg(Rd), reg(Rn), op2)
+
+	// ADD and SUB from PC are how ARM code takes the address of a literal
+	if Rn == 15 && bits(opcode, 25, 1) == 1 && (Opcode == 0b0100 || Opcode == 0b0010) {
+		offset := armImmediate(opcode)
+		if Opcode == 0b0010 {
+			offset = -offset
+		}
+		text += "\t; " + target(addr+8+offset)
+	}
+
+	return text
+}
+
+func armImmediate(opcode uint32) uint32 {
+	amount := bits(opcode, 8, 4) * 2
+	value := bits(opcode, 0, 8)
+	return value>>amount | value<<((32-amount)%32)
+}
+
+func armOperand(opcode uint32) string {
+	if bits(opcode, 25, 1) == 1 {
+		return imm(armImmediate(opcode))
+	}
+	return armShifted(opcode)
+}
+
+// armShifted formats the register operand Rm along with its shift, if any.
+func armShifted(opcode uint32) string {
+	Rm := reg(bits(opcode, 0, 4))
+	shift := bits(opcode, 5, 2)
+
+	if bits(opcode, 4, 1) == 1 {
+		return Rm + ", " + shiftMnemonics[shift] + " " + reg(bits(opcode, 8, 4))
+	}
+
+	amount := bits(opcode, 7, 5)
+	switch {
+	case amount == 0 && shift == 0:
+		return Rm
+	case amount == 0 && shift == 3:
+		return Rm + ", rrx"
+	case amount == 0:
+		amount = 32
+	}
+
+	return fmt.Sprintf("%s, %s #%d", Rm, shiftMnemonics[shift], amount)
+}
+
+func armBranch(opcode, addr uint32, cond string) string {
+	mnemonic := "b"
+	if bits(opcode, 24, 1) == 1 {
+		mnemonic = "bl"
+	}
+
+	offset := signed(bits(opcode, 0, 24), 24) * 4
+	return op(mnemonic+cond, target(addr+8+uint32(offset)))
+}
+
+func armBranchX(opcode uint32, cond string) string {
+	switch bits(opcode, 4, 4) {
+	case 0b0001:
+		return op("bx"+cond, reg(bits(opcode, 0, 4)))
+	case 0b0011:
+		return op("blx"+cond, reg(bits(opcode, 0, 4)))
+	default:
+		return op(".word", fmt.Sprintf("0x%08x", opcode))
+	}
+}
+
+func armMultiply(opcode uint32, cond string) string {
+	A := bits(opcode, 21, 1)
+	S := bits(opcode, 20, 1)
+	Rd := reg(bits(opcode, 16, 4))
+	Rn := reg(bits(opcode, 12, 4))
+	Rs := reg(bits(opcode, 8, 4))
+	Rm := reg(bits(opcode, 0, 4))
+
+	mnemonic := "mul"
+	if A == 1 {
+		mnemonic = "mla"
+	}
+	if S == 1 {
+		mnemonic += "s"
+	}
+
+	if A == 1 {
+		return op(mnemonic+cond, Rd, Rm, Rs, Rn)
+	}
+	return op(mnemonic+cond, Rd, Rm, Rs)
+}
+
+func armMultiplyLong(opcode uint32, cond string) string {
+	U := bits(opcode, 22, 1)
+	A := bits(opcode, 21, 1)
+	S := bits(opcode, 20, 1)
+	RdHi := reg(bits(opcode, 16, 4))
+	RdLo := reg(bits(opcode, 12, 4))
+	Rs := reg(bits(opcode, 8, 4))
+	Rm := reg(bits(opcode, 0, 4))
+
+	mnemonic := [2][2]string{{"umull", "umlal"}, {"smull", "smlal"}}[U][A]
+	if S == 1 {
+		mnemonic += "s"
+	}
+
+	return op(mnemonic+cond, RdLo, RdHi, Rm, Rs)
+}
+
+func armSwap(opcode uint32, cond string) string {
+	mnemonic := "swp"
+	if bits(opcode, 22, 1) == 1 {
+		mnemonic = "swpb"
+	}
+
+	return op(mnemonic+cond, reg(bits(opcode, 12, 4)), reg(bits(opcode, 0, 4)), "["+reg(bits(opcode, 16, 4))+"]")
+}
+
+func armPSR(opcode uint32, cond string) string {
+	psr := "cpsr"
+	if bits(opcode, 22, 1) == 1 {
+		psr = "spsr"
+	}
+
+	if bits(opcode, 21, 1) == 0 {
+		return op("mrs"+cond, reg(bits(opcode, 12, 4)), psr)
+	}
+
+	fields := ""
+	for i, f := range "cxsf" {
+		if bits(opcode, uint8(16+i), 1) == 1 {
+			fields += string(f)
+		}
+	}
+	if fields != "" {
+		psr += "_" + fields
+	}
+
+	if bits(opcode, 25, 1) == 1 {
+		return op("msr"+cond, psr, imm(armImmediate(opcode)))
+	}
+	return op("msr"+cond, psr, reg(bits(opcode, 0, 4)))
+}
+
+// armAddress formats a load or store address, resolving PC-relative
+// immediate offsets to the address they name.
+func armAddress(opcode, addr uint32, offset string, value uint32, immediate bool) string {
+	P := bits(opcode, 24, 1)
+	U := bits(opcode, 23, 1) == 1
+	W := bits(opcode, 21, 1)
+	Rn := bits(opcode, 16, 4)
+
+	if P == 0 {
+		return "[" + reg(Rn) + "], " + offset
+	}
+
+	text := "[" + reg(Rn)
+	if !immediate || value != 0 {
+		text += ", " + offset
+	}
+	text += "]"
+	if W == 1 {
+		text += "!"
+	}
+
+	if Rn == 15 && immediate && W == 0 {
+		if !U {
+			value = -value
+		}
+		text += "\t; " + target(addr+8+value)
+	}
+
+	return text
+}
+
+func armMemory(opcode, addr uint32, cond string) string {
+	I := bits(opcode, 25, 1)
+	P := bits(opcode, 24, 1)
+	U := bits(opcode, 23, 1) == 1
+	B := bits(opcode, 22, 1)
+	W := bits(opcode, 21, 1)
+	L := bits(opcode, 20, 1)
+	Rd := bits(opcode, 12, 4)
+
+	mnemonic := "str"
+	if L == 1 {
+		mnemonic = "ldr"
+	}
+	if B == 1 {
+		mnemonic += "b"
+	}
+	if P == 0 && W == 1 {
+		mnemonic += "t"
+	}
+
+	var offset string
+	value := bits(opcode, 0, 12)
+	switch I {
+	case 0:
+		offset = signedImm(U, value)
+	case 1:
+		offset = armShifted(opcode)
+		if !U {
+			offset = "-" + offset
+		}
+	}
+
+	return op(mnemonic+cond, reg(Rd), armAddress(opcode, addr, offset, value, I == 0))
+}
+
+func armMemoryHalf(opcode, addr uint32, cond string) string {
+	U := bits(opcode, 23, 1) == 1
+	I := bits(opcode, 22, 1)
+	L := bits(opcode, 20, 1)
+	Rd := bits(opcode, 12, 4)
+
+	// Opcode 00 and the ARMv5 LDRD and STRD are undefined on the ARM7TDMI.
+	var mnemonic string
+	switch bits(opcode, 5, 2) {
+	case 0b01:
+		mnemonic = [2]string{"strh", "ldrh"}[L]
+	case 0b10:
+		mnemonic = [2]string{"", "ldrsb"}[L]
+	case 0b11:
+		mnemonic = [2]string{"", "ldrsh"}[L]
+	}
+	if mnemonic == "" {
+		return op(".word", fmt.Sprintf("0x%08x", opcode))
+	}
+
+	var offset string
+	value := bits(opcode, 8, 4)<<4 | bits(opcode, 0, 4)
+	switch I {
+	case 0:
+		offset = reg(bits(opcode, 0, 4))
+		if !U {
+			offset = "-" + offset
+		}
+	case 1:
+		offset = signedImm(U, value)
+	}
+
+	return op(mnemonic+cond, reg(Rd), armAddress(opcode, addr, offset, value, I == 1))
+}
+
+func armMemoryBlock(opcode uint32, cond string) string {
+	P := bits(opcode, 24, 1)
+	U := bits(opcode, 23, 1)
+	S := bits(opcode, 22, 1)
+	W := bits(opcode, 21, 1)
+	L := bits(opcode, 20, 1)
+	Rn := bits(opcode, 16, 4)
+	Rlist := bits(opcode, 0, 16)
+
+	list := regList(Rlist)
+	if S == 1 {
+		list += "^"
+	}
+
+	// a writeback STMDB or LDMIA of more than one register on SP is a push or pop
+	if Rn == 13 && W == 1 && S == 0 && Rlist&(Rlist-1) != 0 {
+		switch {
+		case L == 0 && P == 1 && U == 0:
+			return op("push"+cond, list)
+		case L == 1 && P == 0 && U == 1:
+			return op("pop"+cond, list)
+		}
+	}
+
+	mnemonic := [2]string{"stm", "ldm"}[L] + [2][2]string{{"da", "ia"}, {"db", "ib"}}[P][U]
+
+	base := reg(Rn)
+	if W == 1 {
+		base += "!"
+	}
+
+	return op(mnemonic+cond, base, list)
+}
@@ -0,0 +1,72 @@
+package disasm
+
+import "testing"
+
+// TestArm checks the text of ARM opcodes across the condition codes, the
+// shifter operands, register lists and PC-relative targets.
+func TestArm(t *testing.T) {
+	const addr = 0x08000100
+
+	tests := []struct {
+		opcode uint32
+		want   string
+	}{
+		// condition codes
+		{0x0A000000, "beq\t0x08000108"},
+		{0x1AFFFFFE, "bne\t0x08000100"},
+		{0x2A000001, "bcs\t0x0800010c"},
+		{0x3B000000, "blcc\t0x08000108"},
+		{0x4A000000, "bmi\t0x08000108"},
+		{0x5A000000, "bpl\t0x08000108"},
+		{0x6A000000, "bvs\t0x08000108"},
+		{0x7A000000, "bvc\t0x08000108"},
+		{0x8A000000, "bhi\t0x08000108"},
+		{0x9A000000, "bls\t0x08000108"},
+		{0xAA000000, "bge\t0x08000108"},
+		{0xBA000000, "blt\t0x08000108"},
+		{0xCA000000, "bgt\t0x08000108"},
+		{0xDA000000, "ble\t0x08000108"},
+		{0xEA000000, "b\t0x08000108"},
+		{0xFA000000, "bnv\t0x08000108"},
+
+		// shifter operands
+		{0xE1A00101, "mov\tr0, r1, lsl #2"},
+		{0xE1A00021, "mov\tr0, r1, lsr #32"},
+		{0xE1A00041, "mov\tr0, r1, asr #32"},
+		{0xE1A00061, "mov\tr0, r1, rrx"},
+		{0xE1A000E1, "mov\tr0, r1, ror #1"},
+		{0xE1A00211, "mov\tr0, r1, lsl r2"},
+		{0xE0810352, "add\tr0, r1, r2, asr r3"},
+		{0xE3A004FF, "mov\tr0, #0xff000000"},
+		{0xE3A0010A, "mov\tr0, #0x80000002"},
+		{0xE1B00000, "movs\tr0, r0"},
+		{0xE1500001, "cmp\tr0, r1"},
+
+		// register lists
+		{0xE92D4010, "push\t{r4, lr}"},
+		{0xE8BD8010, "pop\t{r4, pc}"},
+		{0xE8B10006, "ldmia\tr1!, {r1, r2}"},
+		{0xE9400003, "stmdb\tr0, {r0, r1}^"},
+		{0xE8D08000, "ldmia\tr0, {pc}^"},
+
+		// PC-relative targets
+		{0xE28F0008, "add\tr0, pc, #8\t; 0x08000110"},
+		{0xE24F0008, "sub\tr0, pc, #8\t; 0x08000100"},
+		{0xE59F0008, "ldr\tr0, [pc, #8]\t; 0x08000110"},
+		{0xE51F0004, "ldr\tr0, [pc, #-4]\t; 0x08000104"},
+		{0xE1DF00B4, "ldrh\tr0, [pc, #4]\t; 0x0800010c"},
+
+		// undefined
+		{0xE1800090, ".word\t0xe1800090"},
+		{0xE1C000D0, ".word\t0xe1c000d0"},
+		{0xE1C000F0, ".word\t0xe1c000f0"},
+		{0xE1D000D0, "ldrsb\tr0, [r0]"},
+		{0xE7F000F0, ".word\t0xe7f000f0"},
+	}
+
+	for _, tt := range tests {
+		if got := Arm(tt.opcode, addr); got != tt.want {
+			t.Errorf("Arm(%08X) = %q, want %q", tt.opcode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package disasm turns ARM and Thumb opcodes into GNU-style assembly text,
+// decoding them with the same encoding classes the CPU dispatches on.
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+var conditions = [16]string{"eq", "ne", "cs", "cc", "mi", "pl", "vs", "vc", "hi", "ls", "ge", "lt", "gt", "le", "", "nv"}
+
+var registers = [16]string{"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10", "r11", "r12", "sp", "lr", "pc"}
+
+func bits(value uint32, bit, size uint8) uint32 {
+	return (value >> bit) & (1<<size - 1)
+}
+
+func signed(value uint32, size uint8) int32 {
+	return int32(value<<(32-size)) >> (32 - size)
+}
+
+func reg(r uint32) string {
+	return registers[r&0xF]
+}
+
+func regList(list uint32) string {
+	var regs []string
+	for r := uint32(0); r < 16; r++ {
+		if bits(list, uint8(r), 1) == 1 {
+			regs = append(regs, reg(r))
+		}
+	}
+	return "{" + strings.Join(regs, ", ") + "}"
+}
+
+func imm(value uint32) string {
+	if value < 10 {
+		return fmt.Sprintf("#%d", value)
+	}
+	return fmt.Sprintf("#0x%x", value)
+}
+
+func signedImm(up bool, value uint32) string {
+	if up {
+		return imm(value)
+	}
+	return "#-" + imm(value)[1:]
+}
+
+func target(addr uint32) string {
+	return fmt.Sprintf("0x%08x", addr)
+}
+
+func op(mnemonic string, operands ...string) string {
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	return mnemonic + "\t" + strings.Join(operands, ", ")
+}
@@ -0,0 +1,154 @@
+package disasm
+
+import (
+	"fmt"
+
+	"github.com/dbut2/sapphire/gba"
+)
+
+var thumbALUMnemonics = [16]string{"ands", "eors", "lsls", "lsrs", "asrs", "adcs", "sbcs", "rors", "tst", "negs", "cmp", "cmn", "orrs", "muls", "bics", "mvns"}
+
+// Thumb disassembles a Thumb opcode found at addr, which PC-relative operands
+// are resolved against. The two halves of a BL are shown on their own; use
+// ThumbBL to join them.
+func Thumb(opcode uint16, addr uint32) string {
+	ins := uint32(opcode)
+
+	switch gba.DecodeThumb(opcode) {
+	case gba.ThumbClassSWI:
+		return op("svc", imm(bits(ins, 0, 8)))
+	case gba.ThumbClassALU:
+		return op(thumbALUMnemonics[bits(ins, 6, 4)], reg(bits(ins, 0, 3)), reg(bits(ins, 3, 3)))
+	case gba.ThumbClassHiReg:
+		return thumbHiReg(ins)
+	case gba.ThumbClassAddSub:
+		return thumbAddSub(ins)
+	case gba.ThumbClassMemoryPCRel:
+		offset := bits(ins, 0, 8) * 4
+		return op("ldr", reg(bits(ins, 8, 3)), "[pc, "+imm(offset)+"]") + "\t; " + target((addr+4)&^2+offset)
+	case gba.ThumbClassMemoryReg:
+		mnemonic := [4]string{"str", "strb", "ldr", "ldrb"}[bits(ins, 10, 2)]
+		return op(mnemonic, reg(bits(ins, 0, 3)), "["+reg(bits(ins, 3, 3))+", "+reg(bits(ins, 6, 3))+"]")
+	case gba.ThumbClassMemorySign:
+		mnemonic := [4]string{"strh", "ldrsb", "ldrh", "ldrsh"}[bits(ins, 10, 2)]
+		return op(mnemonic, reg(bits(ins, 0, 3)), "["+reg(bits(ins, 3, 3))+", "+reg(bits(ins, 6, 3))+"]")
+	case gba.ThumbClassMemoryImm:
+		mnemonic := [4]string{"str", "ldr", "strb", "ldrb"}[bits(ins, 11, 2)]
+		offset := bits(ins, 6, 5)
+		if bits(ins, 12, 1) == 0 {
+			offset *= 4
+		}
+		return op(mnemonic, reg(bits(ins, 0, 3)), thumbAddress(reg(bits(ins, 3, 3)), offset))
+	case gba.ThumbClassMemoryHalf:
+		mnemonic := [2]string{"strh", "ldrh"}[bits(ins, 11, 1)]
+		return op(mnemonic, reg(bits(ins, 0, 3)), thumbAddress(reg(bits(ins, 3, 3)), bits(ins, 6, 5)*2))
+	case gba.ThumbClassMemorySPRel:
+		mnemonic := [2]string{"str", "ldr"}[bits(ins, 11, 1)]
+		return op(mnemonic, reg(bits(ins, 8, 3)), thumbAddress("sp", bits(ins, 0, 8)*4))
+	case gba.ThumbClassMemoryPCSP:
+		offset := bits(ins, 0, 8) * 4
+		if bits(ins, 11, 1) == 1 {
+			return op("add", reg(bits(ins, 8, 3)), "sp", imm(offset))
+		}
+		return op("add", reg(bits(ins, 8, 3)), "pc", imm(offset)) + "\t; " + target((addr+4)&^2+offset)
+	case gba.ThumbClassMemoryBlock:
+		mnemonic := [2]string{"stmia", "ldmia"}[bits(ins, 11, 1)]
+		return op(mnemonic, reg(bits(ins, 8, 3))+"!", regList(bits(ins, 0, 8)))
+	case gba.ThumbClassShift:
+		mnemonic := [3]string{"lsls", "lsrs", "asrs"}[bits(ins, 11, 2)]
+		amount := bits(ins, 6, 5)
+		switch {
+		case amount == 0 && mnemonic == "lsls":
+			return op("movs", reg(bits(ins, 0, 3)), reg(bits(ins, 3, 3)))
+		case amount == 0:
+			amount = 32
+		}
+		return op(mnemonic, reg(bits(ins, 0, 3)), reg(bits(ins, 3, 3)), fmt.Sprintf("#%d", amount))
+	case gba.ThumbClassImm:
+		mnemonic := [4]string{"movs", "cmp", "adds", "subs"}[bits(ins, 11, 2)]
+		return op(mnemonic, reg(bits(ins, 8, 3)), imm(bits(ins, 0, 8)))
+	case gba.ThumbClassBranchCond:
+		offset := signed(bits(ins, 0, 8), 8) * 2
+		return op("b"+conditions[bits(ins, 8, 4)], target(addr+4+uint32(offset)))
+	case gba.ThumbClassBranchUncond:
+		offset := signed(bits(ins, 0, 11), 11) * 2
+		return op("b", target(addr+4+uint32(offset)))
+	case gba.ThumbClassBranchLink1:
+		offset := signed(bits(ins, 0, 11), 11) << 12
+		return op("add", "lr", "pc", fmt.Sprintf("#%d", offset)) + "\t; bl prefix"
+	case gba.ThumbClassBranchLink2:
+		mnemonic := "bl"
+		if bits(ins, 12, 1) == 0 {
+			mnemonic = "blx"
+		}
+		return op(mnemonic, "lr", imm(bits(ins, 0, 11)*2)) + "\t; bl suffix"
+	case gba.ThumbClassPushPop:
+		list := bits(ins, 0, 8)
+		if bits(ins, 11, 1) == 1 {
+			return op("pop", regList(list|bits(ins, 8, 1)<<15))
+		}
+		return op("push", regList(list|bits(ins, 8, 1)<<14))
+	case gba.ThumbClassAddSP:
+		offset := bits(ins, 0, 7) * 4
+		if bits(ins, 7, 1) == 1 {
+			return op("sub", "sp", imm(offset))
+		}
+		return op("add", "sp", imm(offset))
+	default:
+		return op(".short", fmt.Sprintf("0x%04x", opcode))
+	}
+}
+
+// ThumbBL disassembles the two halves of a BL found at addr as the single
+// branch they make up.
+func ThumbBL(prefix, suffix uint16, addr uint32) string {
+	if gba.DecodeThumb(prefix) != gba.ThumbClassBranchLink1 || gba.DecodeThumb(suffix) != gba.ThumbClassBranchLink2 {
+		return Thumb(prefix, addr)
+	}
+
+	offset := uint32(signed(bits(uint32(prefix), 0, 11), 11)<<12) + bits(uint32(suffix), 0, 11)*2
+
+	mnemonic := "bl"
+	if bits(uint32(suffix), 12, 1) == 0 {
+		mnemonic = "blx"
+	}
+
+	return op(mnemonic, target(addr+4+offset))
+}
+
+func thumbAddress(base string, offset uint32) string {
+	if offset == 0 {
+		return "[" + base + "]"
+	}
+	return "[" + base + ", " + imm(offset) + "]"
+}
+
+func thumbAddSub(ins uint32) string {
+	mnemonic := [2]string{"adds", "subs"}[bits(ins, 9, 1)]
+
+	operand := reg(bits(ins, 6, 3))
+	if bits(ins, 10, 1) == 1 {
+		operand = imm(bits(ins, 6, 3))
+	}
+
+	return op(mnemonic, reg(bits(ins, 0, 3)), reg(bits(ins, 3, 3)), operand)
+}
+
+func thumbHiReg(ins uint32) string {
+	Rd := reg(bits(ins, 0, 3) + bits(ins, 7, 1)<<3)
+	Rs := reg(bits(ins, 3, 3) + bits(ins, 6, 1)<<3)
+
+	switch bits(ins, 8, 2) {
+	case 0b00:
+		return op("add", Rd, Rs)
+	case 0b01:
+		return op("cmp", Rd, Rs)
+	case 0b10:
+		return op("mov", Rd, Rs)
+	default:
+		if bits(ins, 7, 1) == 1 {
+			return op("blx", Rs)
+		}
+		return op("bx", Rs)
+	}
+}
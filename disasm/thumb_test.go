@@ -0,0 +1,67 @@
+package disasm
+
+import "testing"
+
+// TestThumb checks the text of Thumb opcodes, resolving PC-relative targets
+// from the word-aligned PC.
+func TestThumb(t *testing.T) {
+	const addr = 0x08000102
+
+	tests := []struct {
+		opcode uint16
+		want   string
+	}{
+		// condition codes
+		{0xD0FE, "beq\t0x08000102"},
+		{0xD1FE, "bne\t0x08000102"},
+		{0xDC01, "bgt\t0x08000108"},
+		{0xDE00, ".short\t0xde00"},
+		{0xE7FE, "b\t0x08000102"},
+		{0xE001, "b\t0x08000108"},
+
+		// shifts
+		{0x0000, "movs\tr0, r0"},
+		{0x0FC8, "lsrs\tr0, r1, #31"},
+		{0x1048, "asrs\tr0, r1, #1"},
+
+		// register lists
+		{0xB510, "push\t{r4, lr}"},
+		{0xBD10, "pop\t{r4, pc}"},
+		{0xB401, "push\t{r0}"},
+		{0xBC80, "pop\t{r7}"},
+		{0xC906, "ldmia\tr1!, {r1, r2}"},
+		{0xC0FF, "stmia\tr0!, {r0, r1, r2, r3, r4, r5, r6, r7}"},
+
+		// PC-relative targets
+		{0x4802, "ldr\tr0, [pc, #8]\t; 0x0800010c"},
+		{0x4801, "ldr\tr0, [pc, #4]\t; 0x08000108"},
+		{0xA001, "add\tr0, pc, #4\t; 0x08000108"},
+	}
+
+	for _, tt := range tests {
+		if got := Thumb(tt.opcode, addr); got != tt.want {
+			t.Errorf("Thumb(%04X) = %q, want %q", tt.opcode, got, tt.want)
+		}
+	}
+}
+
+// TestThumbBL expects the two halves of a BL to make up one branch, and a
+// lone prefix to print as itself.
+func TestThumbBL(t *testing.T) {
+	const addr = 0x08000100
+
+	tests := []struct {
+		prefix, suffix uint16
+		want           string
+	}{
+		{0xF000, 0xF801, "bl\t0x08000106"},
+		{0xF7FF, 0xFFFE, "bl\t0x08000100"},
+		{0xF7FF, 0x4770, "add\tlr, pc, #-4096\t; bl prefix"},
+	}
+
+	for _, tt := range tests {
+		if got := ThumbBL(tt.prefix, tt.suffix, addr); got != tt.want {
+			t.Errorf("ThumbBL(%04X, %04X) = %q, want %q", tt.prefix, tt.suffix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gba
+
+// biosSine is the sine table the BIOS affine calls look angles up in, taken
+// from the BIOS image: sin(2πi/256) in 1.14 fixed point.
+var biosSine = func() (table [256]int32) {
+	const at = 0xD1C
+	for i := range table {
+		lo, hi := bios[at+i*2]^biosKey, bios[at+i*2+1]^biosKey
+		table[i] = int32(int16(uint16(hi)<<8 | uint16(lo)))
+	}
+	return table
+}()
+
+// rotScale returns the rotation and scaling matrix for scales sx and sy and
+// the angle in the top byte of angle, as the BIOS works it out. The matrix
+// is in 8.8 fixed point, but kept to 32 bits for the reference point sums.
+func rotScale(sx, sy int32, angle uint32) (pa, pb, pc, pd int32) {
+	theta := angle >> 8 & 0xFF
+	cos, sin := biosSine[(theta+0x40)&0xFF], biosSine[theta]
+	return cos * sx >> 14, -(sin * sx >> 14), sin * sy >> 14, cos * sy >> 14
+}
+
+// bgAffineSet works out the affine parameters of R2 backgrounds from the
+// BgAffineSource entries at R0, writing a BgAffineDest for each to R1: the
+// matrix and the reference point that puts the original centre at the
+// display centre.
+func (c *CPU) bgAffineSet() {
+	source, destination := c.R[0], c.R[1]
+	c.idle(150)
+	for n := int32(c.R[2]); n > 0; n-- {
+		angle := c.readHalf(source + 16)
+		sx := int32(c.readHalfSigned(source + 12))
+		sy := int32(c.readHalfSigned(source + 14))
+		pa, pb, pc, pd := rotScale(sx, sy, angle)
+
+		ox, oy := int32(c.read32(source)), int32(c.read32(source+4))
+		centre := c.read32(source + 8)
+		dx, dy := int32(int16(centre)), int32(centre)>>16
+
+		c.write32(destination+8, uint32(ox-pa*dx-pb*dy))
+		c.write32(destination+12, uint32(oy-pc*dx-pd*dy))
+		c.write16(destination, uint16(pa))
+		c.write16(destination+2, uint16(pb))
+		c.write16(destination+4, uint16(pc))
+		c.write16(destination+6, uint16(pd))
+
+		source += 20
+		destination += 16
+		c.idle(60)
+	}
+}
+
+// objAffineSet works out the matrices of the ObjAffineSource entries at R0,
+// writing PA, PB, PC and PD of each to R1 with R3 bytes between them, so
+// they can go straight into OAM with a stride of 8.
+func (c *CPU) objAffineSet() {
+	source, destination, stride := c.R[0], c.R[1], c.R[3]
+	c.idle(112)
+	for n := int32(c.R[2]); n > 0; n-- {
+		angle := c.readHalf(source + 4)
+		sx := int32(c.readHalfSigned(source))
+		sy := int32(c.readHalfSigned(source + 2))
+		pa, pb, pc, pd := rotScale(sx, sy, angle)
+
+		for _, p := range [...]int32{pa, pb, pc, pd} {
+			c.write16(destination, uint16(p))
+			destination += stride
+		}
+
+		source += 8
+		c.idle(44)
+	}
+}
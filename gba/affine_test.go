@@ -0,0 +1,71 @@
+package gba
+
+import "testing"
+
+// TestAffine compares the affine calls with the BIOS over a spread of
+// scales and angles, with empty and negative counts and a stride into OAM.
+func TestAffine(t *testing.T) {
+	source := WRAM1.Start
+
+	type bg struct {
+		ox, oy         int32
+		dx, dy, sx, sy int16
+		angle          uint16
+	}
+	bgs := []bg{
+		{0x1000, 0x800, 120, 80, 0x100, 0x100, 0},
+		{0x12345, -0x6789, 64, -32, 0x180, -0x80, 0x4000},
+		{-0x100, 0x7FFF00, -120, 200, 0x7FFF, 0x40, 0x2C00},
+		{0, 0, 1, 1, -0x200, 0x333, 0xFFFF},
+	}
+
+	type obj struct {
+		sx, sy int16
+		angle  uint16
+	}
+	objs := []obj{
+		{0x100, 0x100, 0},
+		{0x80, 0x200, 0x8000},
+		{-0x100, 0x7FFF, 0x1234},
+		{0x155, -0x155, 0xC0FF},
+	}
+
+	tests := []struct {
+		name          string
+		swi           uint32
+		destination   uint32
+		count, stride uint32
+	}{
+		{"bg", BgAffineSet, WRAM1.Start + 0x1000, uint32(len(bgs)), 0},
+		{"bg none", BgAffineSet, WRAM1.Start + 0x1000, 0, 0},
+		{"obj", ObjAffineSet, WRAM1.Start + 0x1000, uint32(len(objs)), 2},
+		{"obj oam", ObjAffineSet, OAM.Start + 6, uint32(len(objs)), 8},
+		{"obj negative", ObjAffineSet, WRAM1.Start + 0x1000, 0xFFFFFFFF, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := func(m *Motherboard) {
+				if tt.swi == BgAffineSet {
+					for i, e := range bgs {
+						at := source + uint32(i)*20
+						m.Memory.Set32(at, uint32(e.ox), false, false)
+						m.Memory.Set32(at+4, uint32(e.oy), false, false)
+						for j, v := range []int16{e.dx, e.dy, e.sx, e.sy, int16(e.angle)} {
+							m.Memory.Set16(at+8+uint32(j)*2, uint16(v), false, false)
+						}
+					}
+				} else {
+					for i, e := range objs {
+						at := source + uint32(i)*8
+						for j, v := range []int16{e.sx, e.sy, int16(e.angle)} {
+							m.Memory.Set16(at+uint32(j)*2, uint16(v), false, false)
+						}
+					}
+				}
+				m.CPU.R[0], m.CPU.R[1], m.CPU.R[2], m.CPU.R[3] = source, tt.destination, tt.count, tt.stride
+			}
+			compareSWI(t, tt.swi, tt.destination, setup)
+		})
+	}
+}
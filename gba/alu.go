@@ -68,6 +68,10 @@ func MVN(left, right, carry uint32) (value uint64) { // value = NOT right
 	return uint64(^right)
 }
 
-func MUL(left, right, carry uint32) (value uint64) {
+func MUL(left, right, carry uint32) (value uint64) { // value = left * right
 	return uint64(left) * uint64(right)
 }
+
+func SMULL(left, right, carry uint32) (value uint64) { // value = signed left * signed right
+	return uint64(int64(int32(left)) * int64(int32(right)))
+}
@@ -0,0 +1,145 @@
+package gba
+
+// The arithmetic calls use the BIOS algorithms rather than Go's operators,
+// as games depend on the exact results, rounding and all.
+
+// arcTanCoefficients are the terms of the BIOS arctangent polynomial in
+// 1.14 fixed point, highest power first.
+var arcTanCoefficients = [...]int32{0x390, 0x91C, 0xFB6, 0x16AA, 0x2081, 0x3651, 0xA2F9}
+
+// biosDivide divides n by d as the BIOS loop does, shifting d up past half
+// of n and then subtracting it back down a bit at a time. d must not be 0
+// unless n is 0 or 1, as the BIOS never finishes shifting it.
+func (c *CPU) biosDivide(n, d uint32) (quotient, remainder uint32) {
+	shifted := d
+	for shifted < n>>1 {
+		shifted <<= 1
+		c.idle(5)
+	}
+	if shifted == n>>1 {
+		shifted <<= 1
+	}
+
+	for {
+		quotient <<= 1
+		if n >= shifted {
+			n -= shifted
+			quotient |= 1
+		}
+		c.idle(8)
+		if shifted == d {
+			return quotient, n
+		}
+		shifted >>= 1
+	}
+}
+
+// divide divides number by denom as signed values. The quotient rounds
+// towards zero and the remainder takes the sign of number.
+func (c *CPU) divide(number, denom uint32) (quotient, remainder, abs uint32) {
+	n, d := number, denom
+	if int32(n) < 0 {
+		n = -n
+	}
+	if int32(d) < 0 {
+		d = -d
+	}
+	abs, remainder = c.biosDivide(n, d)
+	c.idle(14)
+
+	quotient = abs
+	if (number^denom)>>31 == 1 {
+		quotient = -quotient
+	}
+	if int32(number) < 0 {
+		remainder = -remainder
+	}
+	return quotient, remainder, abs
+}
+
+// div implements Div and DivArm, leaving the quotient, remainder and
+// absolute quotient in R0, R1 and R3. Dividing anything but 0 or ±1 by zero
+// hangs the BIOS, so those calls are left to it.
+func (c *CPU) div(number, denom uint32) {
+	if denom == 0 && number+1 > 2 {
+		c.exception(0x08)
+		return
+	}
+
+	c.idle(60)
+	c.R[0], c.R[1], c.R[3] = c.divide(number, denom)
+}
+
+// sqrt returns the integer square root of x, rounded down, by the BIOS
+// Newton's method iteration.
+func (c *CPU) sqrt(x uint32) uint32 {
+	c.idle(90)
+	root := uint32(1)
+	for v := x; v > root; v >>= 1 {
+		root <<= 1
+		c.idle(5)
+	}
+
+	for {
+		guess := root
+		quotient, _ := c.biosDivide(x, guess)
+		root = (guess + quotient) >> 1
+		c.idle(9)
+		if root >= guess {
+			return guess
+		}
+	}
+}
+
+// arcTan returns the angle of the 1.14 fixed point tangent t, from -0x4000
+// to 0x4000 with 0x10000 a full turn. t should lie between -1 and 1.
+func (c *CPU) arcTan(t int32) int32 {
+	a := -(t * t >> 14)
+	b := int32(0xA9)
+	for _, k := range arcTanCoefficients {
+		b = a*b>>14 + k
+	}
+	c.idle(75)
+	return t * b >> 16
+}
+
+// arcTan2 returns the angle of the point x, y from 0 to 0xFFFF for a full
+// turn. The smaller of the two is divided by the larger, so arcTan is only
+// given tangents between -1 and 1, and the angle is found from the octant.
+func (c *CPU) arcTan2(x, y int32) uint32 {
+	c.idle(110)
+	switch {
+	case y == 0 && x >= 0:
+		return 0
+	case y == 0:
+		return 0x8000
+	case x == 0 && y >= 0:
+		return 0x4000
+	case x == 0:
+		return 0xC000
+	}
+
+	atan := func(n, d int32) int32 {
+		quotient, _, _ := c.divide(uint32(n<<14), uint32(d))
+		return c.arcTan(int32(quotient))
+	}
+
+	var angle int32
+	switch {
+	case y >= 0 && x >= 0 && x >= y:
+		angle = atan(y, x)
+	case y >= 0 && (x >= 0 || -x < y):
+		angle = 0x4000 - atan(x, y)
+	case y >= 0:
+		angle = 0x8000 + atan(y, x)
+	case x > 0 && x < -y:
+		angle = 0xC000 - atan(x, y)
+	case x > 0:
+		angle = 0x10000 + atan(y, x)
+	case -x > -y:
+		angle = 0x8000 + atan(y, x)
+	default:
+		angle = 0xC000 - atan(x, y)
+	}
+	return uint32(angle)
+}
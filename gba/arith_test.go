@@ -0,0 +1,94 @@
+package gba
+
+import "testing"
+
+// TestArith checks the arithmetic calls against results from the BIOS,
+// running it too to make sure the table agrees with it.
+func TestArith(t *testing.T) {
+	neg := func(v int32) uint32 { return uint32(v) }
+
+	tests := []struct {
+		swi    uint32
+		r0, r1 uint32
+		want   []uint32 // R0, R1 and R3, as far as the call defines them
+	}{
+		{Div, 100, 7, []uint32{14, 2, 14}},
+		{Div, neg(-1234), 10, []uint32{neg(-123), neg(-4), 123}},
+		{Div, 1234, neg(-10), []uint32{neg(-123), 4, 123}},
+		{Div, neg(-1234), neg(-10), []uint32{123, neg(-4), 123}},
+		{Div, 7, 100, []uint32{0, 7, 0}},
+		{Div, 0x80000000, neg(-1), []uint32{0x80000000, 0, 0x80000000}},
+		{Div, 0x7FFFFFFF, 2, []uint32{0x3FFFFFFF, 1, 0x3FFFFFFF}},
+		{Div, neg(-1), 3, []uint32{0, neg(-1), 0}},
+		{Div, 0, 0, []uint32{1, 0, 1}},
+		{Div, 1, 0, []uint32{1, 1, 1}},
+		{Div, neg(-1), 0, []uint32{neg(-1), neg(-1), 1}},
+		{DivArm, 10, neg(-1234), []uint32{neg(-123), neg(-4), 123}},
+		{DivArm, 3, 100, []uint32{33, 1, 33}},
+
+		{Sqrt, 0, 0, []uint32{0}},
+		{Sqrt, 1, 0, []uint32{1}},
+		{Sqrt, 3, 0, []uint32{1}},
+		{Sqrt, 15, 0, []uint32{3}},
+		{Sqrt, 16, 0, []uint32{4}},
+		{Sqrt, 99, 0, []uint32{9}},
+		{Sqrt, 123456789, 0, []uint32{11111}},
+		{Sqrt, 0x80000000, 0, []uint32{0xB504}},
+		{Sqrt, 0xFFFFFFFF, 0, []uint32{0xFFFF}},
+
+		{ArcTan, 0, 0, []uint32{0}},
+		{ArcTan, 0x4000, 0, []uint32{0x2000}},
+		{ArcTan, neg(-0x4000), 0, []uint32{neg(-0x2000)}},
+		{ArcTan, 0x2000, 0, []uint32{0x12E4}},
+		{ArcTan, 0x1000, 0, []uint32{0x9FB}},
+		{ArcTan, neg(-0x123), 0, []uint32{neg(-0xBA)}},
+		{ArcTan, 0x10000, 0, []uint32{neg(-0x5D07)}},
+
+		{ArcTan2, 1, 0, []uint32{0}},
+		{ArcTan2, neg(-1), 0, []uint32{0x8000}},
+		{ArcTan2, 0, 1, []uint32{0x4000}},
+		{ArcTan2, 0, neg(-1), []uint32{0xC000}},
+		{ArcTan2, 100, 100, []uint32{0x2000}},
+		{ArcTan2, 100, 50, []uint32{0x12E4}},
+		{ArcTan2, 50, 100, []uint32{0x2D1C}},
+		{ArcTan2, neg(-50), 100, []uint32{0x52E4}},
+		{ArcTan2, neg(-100), 50, []uint32{0x6D1C}},
+		{ArcTan2, neg(-100), neg(-50), []uint32{0x92E4}},
+		{ArcTan2, neg(-50), neg(-100), []uint32{0xAD1C}},
+		{ArcTan2, 50, neg(-100), []uint32{0xD2E4}},
+		{ArcTan2, 100, neg(-50), []uint32{0xED1C}},
+		{ArcTan2, 0x10000, neg(-1), []uint32{0x10000}},
+		{ArcTan2, 0x1234, 0x5678, []uint32{0x378C}},
+	}
+
+	for _, tt := range tests {
+		setup := func(m *Motherboard) {
+			m.CPU.R[0], m.CPU.R[1] = tt.r0, tt.r1
+		}
+		for _, bios := range []bool{true, false} {
+			c := callSWI(t, tt.swi, bios, setup).CPU
+			got := []uint32{c.R[0], c.R[1], c.R[3]}[:len(tt.want)]
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("SWI %02X (%08X, %08X) with bios %v = %08X, want %08X", tt.swi, tt.r0, tt.r1, bios, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestDivByZero expects a division the BIOS never finishes to be handed to
+// it rather than given a result.
+func TestDivByZero(t *testing.T) {
+	m := loadSource("svc 0x60000", false, func(c *CPU) {
+		c.R[0], c.R[1] = 2, 0
+	})
+	c := m.CPU
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if c.curr != 0x08 || c.cpsrMode() != SVC {
+		t.Errorf("running %08X in mode %02X, want the SWI vector", c.curr, c.cpsrMode())
+	}
+}
@@ -1,156 +1,157 @@
 package gba
 
-import (
-	"fmt"
-)
-
 func (c *CPU) Arm(instruction uint32) {
 	if !c.cond(ReadBits(instruction, 28, 4)) {
 		return
 	}
 
-	switch {
-	case instruction&0b0000_1111_1111_1111_1111_1111_0000_0000 == 0b0000_0001_0010_1111_1111_1111_0000_0000:
-		c.ArmBranchX(instruction)
-	case instruction&0b0000_1101_1001_0000_0000_0000_0000_0000 == 0b0000_0001_0000_0000_0000_0000_0000_0000:
-		c.ArmPSR(instruction)
-	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1111_0000_0000_0000_0000_0000_0000:
-		c.ArmSWI(instruction)
-	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1000_0000_0000_0000_0000_0000_0000:
-		c.ArmMemoryBlock(instruction)
-	case instruction&0b0000_1110_0000_0000_0000_0000_1001_0000 == 0b0000_0000_0000_0000_0000_0000_1001_0000:
-		c.Arm_MemoryHalf(instruction)
-	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1010_0000_0000_0000_0000_0000_0000:
-		c.ArmBranch(instruction)
-	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0100_0000_0000_0000_0000_0000_0000:
-		c.ArmMemory(instruction)
-	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1110_0000_0000_0000_0000_0000_0000,
-		instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1100_0000_0000_0000_0000_0000_0000,
-		instruction&0b0000_1111_1110_0000_0000_0000_0000_0000 == 0b0000_1100_0100_0000_0000_0000_0000_0000:
-		noins(instruction)
-	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0000_0000_0000_0000_0000_0000_0000:
-		c.ArmALU(instruction)
-	default:
-		noins(instruction)
-	}
+	armTable[armIndex(instruction)](c, instruction)
 }
 
-func (c *CPU) ArmALU(instruction uint32) {
-	Opcode := ReadBits(instruction, 21, 4)
+type aluOp struct {
+	do      func(left, right, carry uint32) (value uint64)
+	flagger func(left, right uint32, value uint64) (N, Z, C, V bool)
+	logic   bool
+	void    bool
+}
 
-	var doOp func(Rn, Op2, Cy uint32) (value uint64)
-	var flagger func(left, right uint32, value uint64) (N, Z, C, V bool)
-	logic := false
-	void := false
+var aluOps = [16]aluOp{
+	0b0000: {do: AND, logic: true},
+	0b0001: {do: EOR, logic: true},
+	0b0010: {do: SUB, flagger: FlagArithSub},
+	0b0011: {do: RSB, flagger: FlagArithReSub},
+	0b0100: {do: ADD, flagger: FlagArithAdd},
+	0b0101: {do: ADC, flagger: FlagArithAdd},
+	0b0110: {do: SBCArm, flagger: FlagArithSub},
+	0b0111: {do: RSC, flagger: FlagArithReSub},
+	0b1000: {do: TST, logic: true, void: true},
+	0b1001: {do: TEQ, logic: true, void: true},
+	0b1010: {do: CMP, flagger: FlagArithSub, void: true},
+	0b1011: {do: CMN, flagger: FlagArithAdd, void: true},
+	0b1100: {do: ORR, logic: true},
+	0b1101: {do: MOV, logic: true},
+	0b1110: {do: BIC, logic: true},
+	0b1111: {do: MVN, logic: true},
+}
 
-	switch Opcode {
-	case 0b0000:
-		doOp = AND
-		flagger = FlagLogic
-		logic = true
-	case 0b0001:
-		doOp = EOR
-		flagger = FlagLogic
-		logic = true
-	case 0b0010:
-		doOp = SUB
-		flagger = FlagArithSub
-	case 0b0011:
-		doOp = RSB
-		flagger = FlagArithReSub
-	case 0b0100:
-		doOp = ADD
-		flagger = FlagArithAdd
-	case 0b0101:
-		doOp = ADC
-		flagger = FlagArithAdd
-	case 0b0110:
-		doOp = SBCArm
-		flagger = FlagArithSub
-	case 0b0111:
-		doOp = RSC
-		flagger = FlagArithReSub
-	case 0b1000:
-		doOp = TST
-		flagger = FlagLogic
-		logic = true
-		void = true
-	case 0b1001:
-		doOp = TEQ
-		flagger = FlagLogic
-		logic = true
-		void = true
-	case 0b1010:
-		doOp = CMP
-		flagger = FlagArithSub
-		void = true
-	case 0b1011:
-		doOp = CMN
-		flagger = FlagArithAdd
-		void = true
-	case 0b1100:
-		doOp = ORR
-		flagger = FlagLogic
-		logic = true
-	case 0b1101:
-		doOp = MOV
-		flagger = FlagLogic
-		logic = true
-	case 0b1110:
-		doOp = BIC
-		flagger = FlagLogic
-		logic = true
-	case 0b1111:
-		doOp = MVN
-		flagger = FlagLogic
-		logic = true
-	default:
-		noins(instruction)
-	}
+func (c *CPU) ArmALU(instruction uint32) {
+	c.armALU(instruction, &aluOps[ReadBits(instruction, 21, 4)])
+}
 
+func (c *CPU) armALU(instruction uint32, op *aluOp) {
 	Rd := ReadBits(instruction, 12, 4)
 	Rn := c.Arm_Rn(instruction)
-	Op2 := c.Arm_Op2(instruction)
+	Op2, shiftCarry := c.Arm_Op2(instruction)
 	Cy := ReadBits(c.CPSR, 29, 1)
 
 	S := ReadBits(instruction, 20, 1)
 
-	value := doOp(Rn, Op2, Cy)
+	value := op.do(Rn, Op2, Cy)
 
-	if !void {
+	if !op.void {
 		c.R[Rd] = uint32(value)
-
-		if Rd == 15 {
-			c.prefetchFlush()
-		}
 	}
 
-	N, Z, C, V := flagger(Rn, Op2, value)
+	var N, Z, C, V bool
+	if op.logic {
+		N, Z, C = FlagLogic(value, shiftCarry)
+	} else {
+		N, Z, C, V = op.flagger(Rn, Op2, value)
+	}
 
 	switch {
-	case S == 1 && Rd != 15 && logic:
+	case S == 1 && Rd != 15 && op.logic:
+		c.cpsrSetC(C)
 		c.cpsrSetZ(Z)
 		c.cpsrSetN(N)
-	case S == 1 && Rd != 15 && !logic:
+	case S == 1 && Rd != 15 && !op.logic:
 		c.cpsrSetV(V)
 		c.cpsrSetC(C)
 		c.cpsrSetZ(Z)
 		c.cpsrSetN(N)
-	case S == 1 && Rd == 15 && !void:
-		c.cpsrSetZ(Z)
-		c.cpsrSetN(N)
-		c.cpsrSetC(C)
-		c.cpsrSetV(V)
-
+	case S == 1 && Rd == 15 && !op.void:
 		c.restoreCpsr()
+	}
 
-		cond1 := c.cpsrIRQDisable() == 0
-		cond2 := ReadIORegister(c.Memory, IME) > 0
-		cond3 := ReadIORegister(c.Memory, IE)&ReadIORegister(c.Memory, IF) > 0
-		if cond1 && cond2 && cond3 {
-			c.exception(0x18)
+	// the pipeline refills once CPSR is settled, in the state returned to
+	if Rd == 15 && !op.void {
+		c.prefetchFlush()
+	}
+}
+
+func (c *CPU) ArmMultiply(instruction uint32) {
+	A := ReadBits(instruction, 21, 1)
+	S := ReadBits(instruction, 20, 1)
+	Rd := ReadBits(instruction, 16, 4)
+	Rn := ReadBits(instruction, 12, 4)
+	Rs := ReadBits(instruction, 8, 4)
+	Rm := ReadBits(instruction, 0, 4)
+
+	value := uint32(MUL(c.R[Rm], c.R[Rs], 0))
+	m := multiplyCycles(c.R[Rs], true)
+
+	switch A {
+	case 0: // MUL
+		c.idle(m)
+	case 1: // MLA
+		value += c.R[Rn]
+		c.idle(m + 1)
+	}
+
+	c.R[Rd] = value
+
+	if S == 1 {
+		c.cpsrSetN(ReadBits(value, 31, 1) == 1)
+		c.cpsrSetZ(value == 0)
+	}
+}
+
+func (c *CPU) ArmMultiplyLong(instruction uint32) {
+	U := ReadBits(instruction, 22, 1)
+	A := ReadBits(instruction, 21, 1)
+	S := ReadBits(instruction, 20, 1)
+	RdHi := ReadBits(instruction, 16, 4)
+	RdLo := ReadBits(instruction, 12, 4)
+	Rs := ReadBits(instruction, 8, 4)
+	Rm := ReadBits(instruction, 0, 4)
+
+	var value uint64
+	switch U {
+	case 0: // UMULL / UMLAL
+		value = MUL(c.R[Rm], c.R[Rs], 0)
+	case 1: // SMULL / SMLAL
+		value = SMULL(c.R[Rm], c.R[Rs], 0)
+	}
+
+	m := multiplyCycles(c.R[Rs], U == 1)
+
+	switch A {
+	case 0:
+		c.idle(m + 1)
+	case 1:
+		value += uint64(c.R[RdHi])<<32 | uint64(c.R[RdLo])
+		c.idle(m + 2)
+	}
+
+	c.R[RdLo] = uint32(value)
+	c.R[RdHi] = uint32(value >> 32)
+
+	if S == 1 {
+		c.cpsrSetN(value>>63 == 1)
+		c.cpsrSetZ(value == 0)
+	}
+}
+
+// multiplyCycles returns the internal cycles taken by the multiplier array,
+// which terminates early once the remaining bits of the multiplier are all
+// zero (or, for signed multiplies, all one).
+func multiplyCycles(multiplier uint32, signed bool) uint32 {
+	for m, mask := uint32(1), uint32(0xFFFFFF00); m < 4; m, mask = m+1, mask<<8 {
+		if multiplier&mask == 0 || (signed && multiplier&mask == mask) {
+			return m
 		}
 	}
+	return 4
 }
 
 func (c *CPU) Arm_Rn(instruction uint32) uint32 {
@@ -174,8 +175,9 @@ func (c *CPU) Arm_Rx(instruction uint32, Rx uint32) uint32 {
 	return c.R[Rx]
 }
 
-func (c *CPU) Arm_Op2(instruction uint32) uint32 {
-	S := ReadBits(instruction, 20, 1)
+// Arm_Op2 returns the second operand of a data processing instruction along
+// with the barrel shifter's carry out.
+func (c *CPU) Arm_Op2(instruction uint32) (uint32, bool) {
 	I := ReadBits(instruction, 25, 1)
 	switch I {
 	case 0:
@@ -186,75 +188,29 @@ func (c *CPU) Arm_Op2(instruction uint32) uint32 {
 		switch R {
 		case 0:
 			Is := ReadBits(instruction, 7, 5)
-			return c.ArmShift(st, Rm, Is, S, I)
-		case 1:
-			c.cycle(1)
-			Rs := ReadBits(instruction, 8, 4) & 0b11111111
-			return c.ArmShift(st, Rm, c.R[Rs], S, I)
+			return c.ArmShift(st, Rm, Is, false)
 		default:
-			noins(instruction)
-			return 0
+			c.idle(1)
+			Rs := ReadBits(instruction, 8, 4)
+			return c.ArmShift(st, Rm, c.R[Rs], true)
 		}
-	case 1:
+	default:
 		Is := ReadBits(instruction, 8, 4) * 2
 		nn := ReadBits(instruction, 0, 8)
-		return c.ArmShift(ROR, nn, Is, S, 0)
-	default:
-		noins(instruction)
-		return 0
+		return c.ArmShift(ROR, nn, Is, true)
 	}
 }
 
-func (c *CPU) ArmShift(shiftType uint32, value, amount uint32, S uint32, I uint32) uint32 {
-	switch shiftType {
-	case LSL:
-		if amount == 0 && I == 1 {
-			return value
-		}
-		if amount > 32 {
-			if S == 1 {
-				c.cpsrSetC(false)
-			}
-			return 0
-		}
-		v, carry := ShiftLSL(value, amount)
-		if amount > 0 && S == 1 {
-			c.cpsrSetC(carry)
-		}
-		return v
-	case LSR:
-		if amount == 0 && I == 1 {
-			amount = 32
-		}
-		v, carry := ShiftLSR(value, amount)
-		if amount > 0 && S == 1 {
-			c.cpsrSetC(carry)
-		}
-		return v
-	case ASR:
-		if (amount == 0 && I == 1) || amount > 32 {
-			amount = 32
-		}
-		v, carry := ShiftASR(value, amount)
-		if amount > 0 && S == 1 {
-			c.cpsrSetC(carry)
-		}
-		return v
-	case ROR:
-		if amount == 0 && I == 1 {
-			oldC := c.cpsrC()
-			c.cpsrSetC(ReadBits(value, 0, 1) == 1)
-			v, _ := ShiftROR((value & ^(uint32(1)))|oldC, 1)
-			return v
-		}
-		v, carry := ShiftROR(value, amount)
-		if amount > 0 && S == 1 {
-			c.cpsrSetC(carry)
-		}
-		return v
-	default:
-		panic(fmt.Sprintf("bad shift: %d", shiftType))
+// ArmShift runs the barrel shifter with the current carry flag as its carry
+// in. Amounts taken from a register, or the rotation of an immediate, follow
+// the register rules: only the bottom byte counts and 0 leaves the carry
+// alone. Amounts encoded in the instruction follow ShiftImmediate.
+func (c *CPU) ArmShift(shiftType uint32, value, amount uint32, register bool) (uint32, bool) {
+	carry := c.cpsrC() == 1
+	if register {
+		return Shift(shiftType, value, amount, carry)
 	}
+	return ShiftImmediate(shiftType, value, amount, carry)
 }
 
 func (c *CPU) ArmBranch(instruction uint32) {
@@ -290,7 +246,8 @@ func (c *CPU) ArmBranchX(instruction uint32) {
 	case 0b0011:
 		c.Arm_BLX(instruction)
 	default:
-		noins(instruction)
+		c.undefined(instruction)
+		return
 	}
 
 	c.prefetchFlush()
@@ -332,26 +289,32 @@ func (c *CPU) ArmMRS(instruction uint32) {
 
 	SWP := ReadBits(instruction, 16, 4)
 	if SWP != 0b1111 {
-		noins(instruction)
+		c.undefined(instruction)
+		return
 	}
 
-	switch Psr {
-	case 0:
-		c.R[Rd] = c.CPSR
-	case 1:
+	switch {
+	case Psr == 1 && c.hasSPSR():
 		c.R[Rd] = *c.spsrAddr(c.cpsrMode())
+	default:
+		c.R[Rd] = c.CPSR
 	}
 }
 
+// psrFields are the bits of a PSR written by each field of MSR: control,
+// extension, status and flags.
+var psrFields = [4]uint32{0x000000FF, 0x0000FF00, 0x00FF0000, 0xFF000000}
+
 func (c *CPU) ArmMSR(instruction uint32) {
 	I := ReadBits(instruction, 25, 1)
 	Psr := ReadBits(instruction, 22, 1)
 
-	f := ((ReadBits(instruction, 19, 1) ^ 1) - 1) >> 0
-	s := ((ReadBits(instruction, 18, 1) ^ 1) - 1) >> 8
-	x := ((ReadBits(instruction, 17, 1) ^ 1) - 1) >> 16
-	c2 := ((ReadBits(instruction, 16, 1) ^ 1) - 1) >> 24
-	fieldMask := f | s | x | c2
+	var fieldMask uint32
+	for i, field := range psrFields {
+		if ReadBits(instruction, uint8(16+i), 1) == 1 {
+			fieldMask |= field
+		}
+	}
 
 	var Op uint32
 	switch I {
@@ -364,20 +327,21 @@ func (c *CPU) ArmMSR(instruction uint32) {
 		Op, _ = ShiftROR(imm, rotate)
 	}
 
-	if c.cpsrMode() == USR {
-		fieldMask &= ^c2
+	if Psr == 1 {
+		if c.hasSPSR() {
+			spsr := c.spsrAddr(c.cpsrMode())
+			*spsr = *spsr&^fieldMask | Op&fieldMask
+		}
+		return
 	}
 
-	if Psr == 0 {
-		cpsr := (c.CPSR & ^fieldMask) | (Op & fieldMask)
-
-		mode := ReadBits(cpsr, 0, 5)
-		c.cpsrSetMode(mode)
-
-		c.CPSR = cpsr
-	} else {
-		*c.spsrAddr(c.cpsrMode()) = (*c.spsrAddr(c.cpsrMode()) & ^fieldMask) | (Op & fieldMask)
+	// user mode may only change the flags, and the T bit only changes with BX
+	if c.cpsrMode() == USR {
+		fieldMask &= psrFields[3]
 	}
+	fieldMask &^= 1 << 5
+
+	c.setCPSR(c.CPSR&^fieldMask | Op&fieldMask)
 }
 
 func (c *CPU) ArmMemory(instruction uint32) {
@@ -385,6 +349,7 @@ func (c *CPU) ArmMemory(instruction uint32) {
 	P := ReadBits(instruction, 24, 1)
 	U := ReadBits(instruction, 23, 1)
 	B := ReadBits(instruction, 22, 1)
+	W := ReadBits(instruction, 21, 1)
 	L := ReadBits(instruction, 20, 1)
 	Rn := ReadBits(instruction, 16, 4)
 	Rd := ReadBits(instruction, 12, 4)
@@ -396,41 +361,44 @@ func (c *CPU) ArmMemory(instruction uint32) {
 		Is := ReadBits(instruction, 7, 5)
 		ShiftType := ReadBits(instruction, 5, 2)
 		Rm := ReadBits(instruction, 0, 4)
-		Offset, _ = Shift(ShiftType, c.R[Rm], Is)
+		Offset, _ = ShiftImmediate(ShiftType, c.R[Rm], Is, c.cpsrC() == 1)
 	}
 
 	if U == 0 {
 		Offset = -Offset
 	}
-	addr := c.R[Rn]
-
+	addr, writeback := c.R[Rn], P == 0 || W == 1
 	if P == 1 {
 		addr += Offset
 	}
 
+	// a store reads Rd before writeback, and PC a further instruction ahead
+	value := c.R[Rd]
+	if Rd == 15 {
+		value += 4
+	}
+
+	// a load writes back first, so a loaded base wins
+	if writeback {
+		c.R[Rn] += Offset
+	}
+
 	if L == 1 {
 		if B == 1 {
-			c.R[Rd] = uint32(c.Memory.Read8(addr, true, false))
+			c.R[Rd] = uint32(c.read8(addr))
 		} else {
-			c.R[Rd] = c.Memory.Read32(addr, true, false)
+			c.R[Rd] = c.readWord(addr)
 		}
+		c.idle(1)
 	} else {
 		if B == 1 {
-			c.Memory.Set8(addr, uint8(c.R[Rd]), true, false)
+			c.write8(addr, uint8(value))
 		} else {
-			c.Memory.Set32(addr, c.R[Rd], true, false)
+			c.write32(addr, value)
 		}
 	}
 
-	if P == 0 {
-		addr += Offset
-	}
-
-	if P == 0 || ReadBits(instruction, 21, 1) == 1 {
-		c.R[Rn] = addr
-	}
-
-	if Rd == 15 {
+	if L == 1 && Rd == 15 || writeback && Rn == 15 {
 		c.prefetchFlush()
 	}
 }
@@ -454,65 +422,7 @@ func (c *CPU) Arm_LDM(instruction uint32) {
 	Rn := ReadBits(instruction, 16, 4)
 	Rlist := ReadBits(instruction, 0, 16)
 
-	oldMode := c.cpsrMode()
-	if S == 1 && (Rlist>>15)&1 == 0 {
-		c.cpsrSetMode(USR)
-	}
-
-	address := c.R[Rn]
-	oldRn := c.R[Rn]
-
-	switch {
-	case P == 0 && U == 0: // DA
-		for i := 15; i >= 0; i-- {
-			if (Rlist>>i)&1 == 1 {
-				c.R[i] = c.Memory.Read32(address, true, false)
-				address -= 4
-			}
-		}
-	case P == 1 && U == 0: // DB
-		for i := 15; i >= 0; i-- {
-			if (Rlist>>i)&1 == 1 {
-				address -= 4
-				c.R[i] = c.Memory.Read32(address, true, false)
-			}
-		}
-	case P == 0 && U == 1: // IA
-		for i := 0; i <= 15; i++ {
-			if (Rlist>>i)&1 == 1 {
-				c.R[i] = c.Memory.Read32(address, true, false)
-				address += 4
-			}
-		}
-	case P == 1 && U == 1: // IB
-		for i := 0; i <= 15; i++ {
-			if (Rlist>>i)&1 == 1 {
-				address += 4
-				c.R[i] = c.Memory.Read32(address, true, false)
-			}
-		}
-	}
-
-	if W == 1 {
-		switch U {
-		case 0:
-			c.R[Rn] = oldRn - setBitCount(Rlist)*4
-		case 1:
-			c.R[Rn] = oldRn + setBitCount(Rlist)*4
-		}
-	}
-
-	if S == 1 && (Rlist>>15)&1 == 0 {
-		c.cpsrSetMode(oldMode)
-	}
-
-	if (Rlist>>15)&1 == 1 {
-		if S == 1 {
-			c.restoreCpsr()
-		}
-
-		c.prefetchFlush()
-	}
+	c.ldm(Rn, Rlist, P, U, W, S)
 }
 
 func (c *CPU) Arm_STM(instruction uint32) {
@@ -523,67 +433,100 @@ func (c *CPU) Arm_STM(instruction uint32) {
 	Rn := ReadBits(instruction, 16, 4)
 	Rlist := ReadBits(instruction, 0, 16)
 
-	oldMode := c.cpsrMode()
-	if S == 1 {
-		c.cpsrSetMode(USR)
-	}
+	c.stm(Rn, Rlist, P, U, W, S)
+}
 
-	address := c.R[Rn]
-	oldRn := c.R[Rn]
+// blockAddresses returns the lowest address a block transfer of count
+// registers from base touches, and the base after writeback. An empty list
+// moves the base as if all sixteen registers were transferred.
+func blockAddresses(base, count, P, U uint32) (start, writeback uint32) {
+	size := count * 4
+	if count == 0 {
+		size = 0x40
+	}
 
 	switch {
-	case P == 0 && U == 0: // DA
-		for i := 15; i >= 0; i-- {
-			if (Rlist>>i)&1 == 1 {
-				c.Memory.Set32(address, c.R[i], true, false)
-				address -= 4
-			}
-		}
-	case P == 1 && U == 0: // DB
-		for i := 15; i >= 0; i-- {
-			if (Rlist>>i)&1 == 1 {
-				address -= 4
-				c.Memory.Set32(address, c.R[i], true, false)
-			}
-		}
 	case P == 0 && U == 1: // IA
-		for i := 0; i <= 15; i++ {
-			if (Rlist>>i)&1 == 1 {
-				c.Memory.Set32(address, c.R[i], true, false)
-				address += 4
-			}
-		}
+		return base, base + size
 	case P == 1 && U == 1: // IB
-		for i := 0; i <= 15; i++ {
-			if (Rlist>>i)&1 == 1 {
-				address += 4
-				c.Memory.Set32(address, c.R[i], true, false)
-			}
-		}
+		return base + 4, base + size
+	case P == 0 && U == 0: // DA
+		return base - size + 4, base - size
+	default: // DB
+		return base - size, base - size
+	}
+}
+
+// ldm loads the registers in Rlist from ascending addresses. Writeback
+// happens first so a loaded base wins over it. With S set, a list without
+// R15 loads the user bank, and a list with R15 restores CPSR from SPSR.
+func (c *CPU) ldm(Rn, Rlist, P, U, W, S uint32) {
+	start, writeback := blockAddresses(c.R[Rn], setBitCount(Rlist), P, U)
+	if Rlist == 0 {
+		Rlist = 1 << 15
 	}
+	pc := Rlist>>15&1 == 1
 
 	if W == 1 {
-		switch U {
-		case 0:
-			c.R[Rn] = oldRn - setBitCount(Rlist)*4
-		case 1:
-			c.R[Rn] = oldRn + setBitCount(Rlist)*4
-		}
+		c.R[Rn] = writeback
 	}
 
-	if S == 1 {
-		c.cpsrSetMode(oldMode)
+	address := start
+	for i := uint32(0); i <= 15; i++ {
+		if Rlist>>i&1 == 0 {
+			continue
+		}
+
+		r := &c.R[i]
+		if S == 1 && !pc {
+			r = c.bankedRegister(USR, i)
+		}
+		*r = c.read32(address)
+		address += 4
 	}
 
-	if (Rlist>>15)&1 == 1 {
+	c.idle(1)
+
+	if pc {
 		if S == 1 {
 			c.restoreCpsr()
 		}
-
 		c.prefetchFlush()
 	}
 }
 
+// stm stores the registers in Rlist to ascending addresses, storing R15 a
+// further instruction ahead. The base is written back after the first
+// store, so a base later in the list stores its new value. With S set the
+// user bank is stored.
+func (c *CPU) stm(Rn, Rlist, P, U, W, S uint32) {
+	start, writeback := blockAddresses(c.R[Rn], setBitCount(Rlist), P, U)
+	if Rlist == 0 {
+		Rlist = 1 << 15
+	}
+
+	address := start
+	for i := uint32(0); i <= 15; i++ {
+		if Rlist>>i&1 == 0 {
+			continue
+		}
+
+		value := c.R[i]
+		if S == 1 {
+			value = *c.bankedRegister(USR, i)
+		}
+		if i == 15 {
+			value += c.instructionSize()
+		}
+		c.write32(address, value)
+		address += 4
+
+		if W == 1 {
+			c.R[Rn] = writeback
+		}
+	}
+}
+
 func (c *CPU) Arm_MemoryHalf(instruction uint32) {
 	P := ReadBits(instruction, 24, 1)
 	U := ReadBits(instruction, 23, 1)
@@ -594,13 +537,13 @@ func (c *CPU) Arm_MemoryHalf(instruction uint32) {
 	Rd := ReadBits(instruction, 12, 4)
 	Opcode := ReadBits(instruction, 5, 2)
 
-	var Offset uint32
-
-	switch I {
-	case 0:
-	case 1:
-
+	// LDRD and STRD are ARMv5TE, and undefined on the ARM7TDMI
+	if Opcode == 0 || L == 0 && Opcode != 0b01 {
+		c.undefined(instruction)
+		return
 	}
+
+	var Offset uint32
 	if I == 0 {
 		Rm := ReadBits(instruction, 0, 4)
 		Offset = c.R[Rm]
@@ -611,63 +554,71 @@ func (c *CPU) Arm_MemoryHalf(instruction uint32) {
 	if U == 0 {
 		Offset = -Offset
 	}
-	addr := c.R[Rn]
-
+	addr, writeback := c.R[Rn], P == 0 || W == 1
 	if P == 1 {
 		addr += Offset
 	}
 
-	setRegisters := map[uint32]bool{}
+	// a store reads Rd before writeback, and PC a further instruction ahead
+	value := c.R[Rd]
+	if Rd == 15 {
+		value += 4
+	}
+
+	// a load writes back first, so a loaded base wins
+	if writeback {
+		c.R[Rn] += Offset
+	}
 
+	loaded := false
 	switch L {
-	case 0:
-		switch Opcode {
-		case 0b01: // STRH
-			c.Memory.Set16(addr, uint16(c.R[Rd]), true, false)
-		case 0b10: // LDRD
-			addr &= ^uint32(8)
-			c.R[Rd] = c.Memory.Read32(addr, true, false)
-			c.R[Rd+1] = c.Memory.Read32(addr+4, true, false)
-			setRegisters[Rd] = true
-			setRegisters[Rd+1] = true
-		case 0b11: //STRD
-			addr &= ^uint32(8)
-			c.Memory.Set32(addr, c.R[Rd], true, false)
-			c.Memory.Set32(addr+4, c.R[Rd+1], true, false)
-		default:
-			noins(instruction)
-		}
+	case 0: // STRH
+		c.write16(addr, uint16(value))
 	case 1:
+		loaded = Rd == 15
+
 		switch Opcode {
-		case 0b01:
-			c.R[Rd] = uint32(c.Memory.Read16(addr, true, false))
-			setRegisters[Rd] = true
-		case 0b10:
-			c.R[Rd] = uint32(signify(uint32(c.Memory.Read8(addr, true, false)), 8))
-			setRegisters[Rd] = true
-		case 0b11:
-			c.R[Rd] = uint32(signify(uint32(c.Memory.Read16(addr, true, false)), 16))
-			setRegisters[Rd] = true
-		default:
-			noins(instruction)
+		case 0b01: // LDRH
+			c.R[Rd] = c.readHalf(addr)
+		case 0b10: // LDRSB
+			c.R[Rd] = uint32(signify(uint32(c.read8(addr)), 8))
+		case 0b11: // LDRSH
+			c.R[Rd] = c.readHalfSigned(addr)
 		}
+		c.idle(1)
 	}
 
-	if P == 0 {
-		addr += Offset
+	if loaded || writeback && Rn == 15 {
+		c.prefetchFlush()
 	}
+}
 
-	if P == 0 || W == 1 {
-		c.R[Rn] = addr
-		setRegisters[Rn] = true
-	}
+func (c *CPU) ArmSwap(instruction uint32) {
+	B := ReadBits(instruction, 22, 1)
+	Rn := ReadBits(instruction, 16, 4)
+	Rd := ReadBits(instruction, 12, 4)
+	Rm := ReadBits(instruction, 0, 4)
 
-	if _, ok := setRegisters[15]; ok {
-		c.prefetchFlush()
+	addr := c.R[Rn]
+	source := c.R[Rm]
+
+	switch B {
+	case 0: // SWP
+		value := c.readWord(addr)
+		c.write32(addr, source)
+		c.R[Rd] = value
+	case 1: // SWPB
+		value := c.read8(addr)
+		c.write8(addr, uint8(source))
+		c.R[Rd] = uint32(value)
 	}
+
+	c.idle(1)
 }
 
+// ArmSWI calls the BIOS function in bits 16-23 of the comment, the byte the
+// BIOS reads back to pick it.
 func (c *CPU) ArmSWI(instruction uint32) {
-	nn := ReadBits(instruction, 0, 24)
+	nn := ReadBits(instruction, 16, 8)
 	c.SWI(nn)
 }
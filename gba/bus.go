@@ -0,0 +1,133 @@
+package gba
+
+// Access describes a bus access made by the CPU.
+type Access uint8
+
+const (
+	// Sequential marks an access that continues from the previous access of
+	// the same kind, at the next address.
+	Sequential Access = 1 << iota
+	// Code marks an instruction fetch rather than a data access.
+	Code
+	// Debug marks an access the emulator makes for itself, such as
+	// translating code. It takes no time.
+	Debug
+)
+
+// Bus is everything the CPU sees of the system around it. Writes are given
+// the value as the CPU drives it, and the bus is left to mirror narrow
+// writes or ignore them as the hardware would.
+type Bus interface {
+	Read8(address uint32, access Access) uint8
+	Read16(address uint32, access Access) uint16
+	Read32(address uint32, access Access) uint32
+	Write8(address uint32, value uint8, access Access)
+	Write16(address uint32, value uint16, access Access)
+	Write32(address uint32, value uint32, access Access)
+
+	// Idle lets the system run while the CPU spends cycles internally.
+	Idle(cycles uint32)
+}
+
+// IRQLine is the CPU's interrupt request input.
+type IRQLine interface {
+	IRQ() bool
+}
+
+// BusEvents are the callbacks a bus reports back to its CPU through.
+type BusEvents struct {
+	// Cycle charges the CPU for time spent on the bus.
+	Cycle func(cycles uint32)
+	// Fault stops the CPU on an access the bus cannot serve.
+	Fault func(err error)
+	// Halt stops instruction execution until an interrupt is requested, as
+	// a write to HALTCNT does.
+	Halt func(stop bool)
+	// Write tells the CPU memory has changed under any code translated
+	// from it.
+	Write func(address uint32)
+}
+
+// An EventBus is a Bus that reports back to the CPU. NewCPU attaches the
+// CPU to any bus that implements it.
+type EventBus interface {
+	Bus
+	Attach(events BusEvents)
+}
+
+// cpuBus is the GBA memory map as the CPU sees it, charging each access the
+// waitstates of the region it falls in.
+type cpuBus struct {
+	m *Memory
+}
+
+func (b cpuBus) Attach(events BusEvents) {
+	b.m.events = events
+}
+
+func (b cpuBus) timing(address, size uint32, access Access) {
+	address &^= size - 1
+	seq := access&Sequential != 0
+
+	switch {
+	case access&Debug != 0:
+	case access&Code != 0:
+		b.m.fetch(address, size, seq)
+	default:
+		b.m.cycle(address, size, seq)
+	}
+}
+
+// mapped reports whether an access should go ahead. Debug reads of
+// unmapped memory read as zero rather than faulting the CPU.
+func (b cpuBus) mapped(address uint32, access Access) bool {
+	if access&Debug == 0 {
+		return true
+	}
+	_, ok := b.m.addrBlockData(address)
+	return ok
+}
+
+func (b cpuBus) Read8(address uint32, access Access) uint8 {
+	if !b.mapped(address, access) {
+		return 0
+	}
+	b.timing(address, 1, access)
+	return b.m.Read8(address, false, false)
+}
+
+func (b cpuBus) Read16(address uint32, access Access) uint16 {
+	if !b.mapped(address, access) {
+		return 0
+	}
+	b.timing(address, 2, access)
+	return b.m.Read16(address, false, false)
+}
+
+func (b cpuBus) Read32(address uint32, access Access) uint32 {
+	if !b.mapped(address, access) {
+		return 0
+	}
+	b.timing(address, 4, access)
+	return b.m.Read32(address, false, false)
+}
+
+func (b cpuBus) Write8(address uint32, value uint8, access Access) {
+	b.timing(address, 1, access)
+	b.m.Set8(address, value, false, false)
+}
+
+func (b cpuBus) Write16(address uint32, value uint16, access Access) {
+	b.timing(address, 2, access)
+	b.m.Set16(address, value, false, false)
+}
+
+func (b cpuBus) Write32(address uint32, value uint32, access Access) {
+	b.timing(address, 4, access)
+	b.m.Set32(address, value, false, false)
+}
+
+func (b cpuBus) Idle(cycles uint32) {
+	b.m.events.Cycle(cycles)
+	b.m.idle(cycles)
+}
@@ -0,0 +1,365 @@
+package gba
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The conformance harness runs the community ARM7TDMI single-step tests
+// (github.com/SingleStepTests/ARM7TDMI). Each file holds a list of vectors,
+// each executing one instruction from a known state through a fake bus and
+// recording the resulting state and every bus transaction.
+//
+// make conformance-vectors fetches the first vectors of each of its files
+// into testdata/arm7tdmi/upstream, noting the suite's revision in SOURCE.
+// testdata/arm7tdmi itself holds a few hand-written vectors in the same
+// format, which only check the CPU against what its authors expected. To
+// run the full suite, point ARM7TDMI_TESTS at a directory of its .json or
+// .json.gz files.
+
+const maxReportedVectors = 10
+
+type conformanceState struct {
+	R        [16]uint32 `json:"R"` // R0-R15 as seen in USR and SYS mode
+	RFIQ     [7]uint32  `json:"R_fiq"`
+	RSVC     [2]uint32  `json:"R_svc"`
+	RABT     [2]uint32  `json:"R_abt"`
+	RIRQ     [2]uint32  `json:"R_irq"`
+	RUND     [2]uint32  `json:"R_und"`
+	CPSR     uint32     `json:"CPSR"`
+	SPSR     [5]uint32  `json:"SPSR"` // FIQ, SVC, ABT, IRQ, UND
+	Pipeline [2]uint32  `json:"pipeline"`
+	Access   uint32     `json:"access"`
+}
+
+const (
+	transactionFetch = iota
+	transactionRead
+	transactionWrite
+)
+
+// The access field of the vectors, a transaction's or the one the next fetch
+// will make, carries these flags among others that are not compared.
+const (
+	accessNonsequential = 1 << iota
+	accessSequential
+	accessCode
+
+	accessCompared = accessNonsequential | accessSequential | accessCode
+)
+
+// conformanceAccess converts an access made by the CPU to the vectors' flags.
+func conformanceAccess(access Access) uint32 {
+	flags := uint32(accessNonsequential)
+	if access&Sequential != 0 {
+		flags = accessSequential
+	}
+	if access&Code != 0 {
+		flags |= accessCode
+	}
+	return flags
+}
+
+type conformanceTransaction struct {
+	Kind   uint32 `json:"kind"`
+	Size   uint32 `json:"size"`
+	Addr   uint32 `json:"addr"`
+	Data   uint32 `json:"data"`
+	Cycle  uint32 `json:"cycle"`
+	Access uint32 `json:"access"`
+}
+
+func (t conformanceTransaction) String() string {
+	kind := [...]string{"fetch", "read", "write"}[min(t.Kind, 2)]
+	return fmt.Sprintf("%s%d %08X=%08X @%d access %d", kind, t.Size*8, t.Addr, t.Data, t.Cycle, t.Access&accessCompared)
+}
+
+type conformanceVector struct {
+	Initial      conformanceState         `json:"initial"`
+	Final        conformanceState         `json:"final"`
+	Transactions []conformanceTransaction `json:"transactions"`
+	Opcode       uint32                   `json:"opcode"`
+	BaseAddr     uint32                   `json:"base_addr"`
+}
+
+// testBus serves reads from the vector's transactions and records every
+// access the CPU makes, counting one cycle per access. Debug accesses are
+// served from what the bus has seen without being recorded.
+type testBus struct {
+	expected []conformanceTransaction
+	used     []bool
+	memory   map[uint32]uint32
+
+	cycles uint32
+	got    []conformanceTransaction
+}
+
+func newTestBus(v *conformanceVector) *testBus {
+	return &testBus{
+		expected: v.Transactions,
+		used:     make([]bool, len(v.Transactions)),
+		memory:   make(map[uint32]uint32),
+	}
+}
+
+// access records an access and returns the data the vector has for it,
+// falling back to whatever was last seen at the address.
+func (b *testBus) access(kind, address, size, value uint32, access Access) uint32 {
+	cycle := access&Debug == 0
+	if kind == transactionRead && access&Code != 0 {
+		kind = transactionFetch
+	}
+	aligned := address &^ (size - 1)
+
+	if kind != transactionWrite {
+		value = b.memory[aligned]
+		for i, t := range b.expected {
+			if cycle && !b.used[i] && t.Kind == kind && t.Size == size && t.Addr&^(size-1) == aligned {
+				b.used[i] = true
+				value = t.Data
+				break
+			}
+		}
+	}
+	b.memory[aligned] = value
+
+	if cycle {
+		b.got = append(b.got, conformanceTransaction{Kind: kind, Size: size, Addr: address, Data: value, Cycle: b.cycles, Access: conformanceAccess(access)})
+		b.cycles++
+	}
+
+	return value
+}
+
+func (b *testBus) Read8(address uint32, access Access) uint8 {
+	return uint8(b.access(transactionRead, address, 1, 0, access))
+}
+
+func (b *testBus) Read16(address uint32, access Access) uint16 {
+	return uint16(b.access(transactionRead, address, 2, 0, access))
+}
+
+func (b *testBus) Read32(address uint32, access Access) uint32 {
+	return b.access(transactionRead, address, 4, 0, access)
+}
+
+func (b *testBus) Write8(address uint32, value uint8, access Access) {
+	b.access(transactionWrite, address, 1, uint32(value), access)
+}
+
+func (b *testBus) Write16(address uint32, value uint16, access Access) {
+	b.access(transactionWrite, address, 2, uint32(value), access)
+}
+
+func (b *testBus) Write32(address uint32, value uint32, access Access) {
+	b.access(transactionWrite, address, 4, value, access)
+}
+
+func (b *testBus) Idle(cycles uint32) {
+	b.cycles += cycles
+}
+
+// noIRQ is an interrupt line that is never raised.
+type noIRQ struct{}
+
+func (noIRQ) IRQ() bool {
+	return false
+}
+
+var conformanceSPSRModes = [5]uint32{FIQ, SVC, ABT, IRQ, UND}
+
+func (c *CPU) loadConformanceState(s *conformanceState) {
+	c.CPSR = s.CPSR
+
+	for r := uint32(8); r <= 14; r++ {
+		*c.registerAddr(USR, r) = s.R[r]
+		*c.registerAddr(FIQ, r) = s.RFIQ[r-8]
+	}
+	for r := uint32(13); r <= 14; r++ {
+		*c.registerAddr(SVC, r) = s.RSVC[r-13]
+		*c.registerAddr(ABT, r) = s.RABT[r-13]
+		*c.registerAddr(IRQ, r) = s.RIRQ[r-13]
+		*c.registerAddr(UND, r) = s.RUND[r-13]
+	}
+	for i, mode := range conformanceSPSRModes {
+		*c.spsrAddr(mode) = s.SPSR[i]
+	}
+
+	c.R = s.R
+	for r := uint32(8); r <= 14; r++ {
+		c.R[r] = *c.registerAddr(c.cpsrMode(), r)
+	}
+}
+
+func (c *CPU) conformanceState() conformanceState {
+	s := conformanceState{CPSR: c.CPSR}
+
+	for r := uint32(0); r < 16; r++ {
+		s.R[r] = *c.bankedRegister(USR, r)
+	}
+	for r := uint32(8); r <= 14; r++ {
+		s.RFIQ[r-8] = *c.bankedRegister(FIQ, r)
+	}
+	for r := uint32(13); r <= 14; r++ {
+		s.RSVC[r-13] = *c.bankedRegister(SVC, r)
+		s.RABT[r-13] = *c.bankedRegister(ABT, r)
+		s.RIRQ[r-13] = *c.bankedRegister(IRQ, r)
+		s.RUND[r-13] = *c.bankedRegister(UND, r)
+	}
+	for i, mode := range conformanceSPSRModes {
+		s.SPSR[i] = *c.spsrAddr(mode)
+	}
+
+	s.Pipeline = c.pipeline
+	s.Access = accessNonsequential | accessCode
+	if c.code.valid && c.code.next == c.R[15]&^(c.instructionSize()-1) {
+		s.Access = accessSequential | accessCode
+	}
+
+	return s
+}
+
+// expectsException reports whether the vector ends in an exception handler,
+// which strict mode would have stopped at instead.
+func (v *conformanceVector) expectsException() bool {
+	return v.Final.CPSR&0x1F != v.Initial.CPSR&0x1F && v.Final.R[15] < 0x20+8
+}
+
+// runConformanceVector executes one vector and describes every difference
+// from its expected outcome. The error is the one the step returned.
+func runConformanceVector(v *conformanceVector) ([]string, error) {
+	b := newTestBus(v)
+	c := NewCPU(b, noIRQ{})
+
+	c.loadConformanceState(&v.Initial)
+
+	size := c.instructionSize()
+	c.curr = c.R[15] - 2*size
+	c.next = c.R[15] - size
+	c.pipeline = v.Initial.Pipeline
+	if v.Initial.Access&accessSequential != 0 {
+		c.code = stream{next: c.R[15] &^ (size - 1), valid: true}
+	}
+
+	err := c.Step()
+
+	var diffs []string
+	diff := func(name string, got, want uint32) {
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("%s = %08X, want %08X", name, got, want))
+		}
+	}
+
+	got, want := c.conformanceState(), v.Final
+	for r := range got.R {
+		diff(fmt.Sprintf("r%d", r), got.R[r], want.R[r])
+	}
+	for r := range got.RFIQ {
+		diff(fmt.Sprintf("r%d_fiq", r+8), got.RFIQ[r], want.RFIQ[r])
+	}
+	for r := range 2 {
+		diff(fmt.Sprintf("r%d_svc", r+13), got.RSVC[r], want.RSVC[r])
+		diff(fmt.Sprintf("r%d_abt", r+13), got.RABT[r], want.RABT[r])
+		diff(fmt.Sprintf("r%d_irq", r+13), got.RIRQ[r], want.RIRQ[r])
+		diff(fmt.Sprintf("r%d_und", r+13), got.RUND[r], want.RUND[r])
+	}
+	diff("cpsr", got.CPSR, want.CPSR)
+	for i, name := range []string{"fiq", "svc", "abt", "irq", "und"} {
+		diff("spsr_"+name, got.SPSR[i], want.SPSR[i])
+	}
+	diff("pipeline[0]", got.Pipeline[0], want.Pipeline[0])
+	diff("pipeline[1]", got.Pipeline[1], want.Pipeline[1])
+	diff("access", got.Access, want.Access&accessCompared)
+
+	for i := range max(len(b.got), len(v.Transactions)) {
+		switch {
+		case i >= len(b.got):
+			diffs = append(diffs, fmt.Sprintf("transaction %d missing, want %s", i, v.Transactions[i]))
+		case i >= len(v.Transactions):
+			diffs = append(diffs, fmt.Sprintf("transaction %d = %s, want none", i, b.got[i]))
+		default:
+			g, w := b.got[i], v.Transactions[i]
+			if g.Kind != w.Kind || g.Size != w.Size || g.Addr != w.Addr || g.Data != w.Data || g.Cycle != w.Cycle || g.Access != w.Access&accessCompared {
+				diffs = append(diffs, fmt.Sprintf("transaction %d = %s, want %s", i, g, w))
+			}
+		}
+	}
+
+	return diffs, err
+}
+
+func loadConformanceVectors(path string) ([]conformanceVector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var vectors []conformanceVector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+func TestConformance(t *testing.T) {
+	dir := os.Getenv("ARM7TDMI_TESTS")
+	if dir == "" {
+		dir = filepath.Join("testdata", "arm7tdmi")
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.json", "*.json.gz", "*/*.json", "*/*.json.gz"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Skipf("no test vectors in %s", dir)
+	}
+
+	for _, file := range files {
+		name, _ := filepath.Rel(dir, file)
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".json")
+		t.Run(name, func(t *testing.T) {
+			vectors, err := loadConformanceVectors(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			failed := 0
+			for i := range vectors {
+				diffs, err := runConformanceVector(&vectors[i])
+				if err != nil && !vectors[i].expectsException() {
+					t.Fatalf("vector %d, opcode %08X: %v", i, vectors[i].Opcode, err)
+				}
+				if len(diffs) == 0 {
+					continue
+				}
+
+				failed++
+				if failed <= maxReportedVectors {
+					t.Errorf("vector %d, opcode %08X:\n\t%s", i, vectors[i].Opcode, strings.Join(diffs, "\n\t"))
+				}
+			}
+			if failed > 0 {
+				t.Errorf("%d of %d vectors failed", failed, len(vectors))
+			}
+		})
+	}
+}
@@ -1,27 +1,124 @@
 package gba
 
-import (
-	"fmt"
-)
-
 type CPU struct {
-	*Motherboard
 	CPURegisters
 
+	bus Bus
+	irq IRQLine
+
+	// code and data follow the instruction and data streams so accesses
+	// can be marked sequential. pipeline holds the instructions fetched from
+	// curr and next.
+	code, data stream
+	pipeline   [2]uint32
+
 	curr, next uint32
 	flushed    bool
 
 	cycles uint32
-}
 
-func NewCPU(m *Motherboard) *CPU {
-	return &CPU{Motherboard: m}
+	halted, stopped bool
+
+	// Strict stops emulation with an *UndefinedError when an undefined
+	// instruction or unknown SWI is executed, instead of trapping to the
+	// BIOS like the hardware does.
+	Strict bool
+	fault  *Fault
+
+	// trace records the last instructions executed, for faults to report.
+	trace  [traceLength]Traced
+	traced uint32
+
+	// Recompile runs translated blocks through StepBlock rather than
+	// interpreting one instruction per step.
+	Recompile bool
+	blocks    *blockCache
+}
+
+// NewCPU returns an ARM7TDMI executing from bus and taking interrupts from
+// irq. The CPU is left in reset, for the caller to set up or boot with an
+// exception.
+func NewCPU(bus Bus, irq IRQLine) *CPU {
+	c := &CPU{bus: bus, irq: irq}
+	if b, ok := bus.(EventBus); ok {
+		b.Attach(BusEvents{
+			Cycle: c.cycle,
+			Fault: c.fail,
+			Halt:  c.halt,
+			Write: func(address uint32) { c.blocks.invalidate(address) },
+		})
+	}
+	return c
 }
 
 func (c *CPU) cycle(n uint32) {
 	c.cycles += n
 }
 
+// idle charges internal cycles, during which the CPU leaves the bus alone.
+func (c *CPU) idle(n uint32) {
+	c.bus.Idle(n)
+}
+
+// fetch reads an instruction, continuing the code stream if it follows on
+// from the last fetch.
+func (c *CPU) fetch(address, size uint32) uint32 {
+	access := Code
+	if c.code.access(address, size) {
+		access |= Sequential
+	}
+	c.data.reset()
+
+	if size == 2 {
+		return uint32(c.bus.Read16(address, access))
+	}
+	return c.bus.Read32(address, access)
+}
+
+// dataAccess returns the access type of a data access, continuing the data
+// stream if it follows on from the last one.
+func (c *CPU) dataAccess(address, size uint32) Access {
+	var access Access
+	if c.data.access(address&^(size-1), size) {
+		access |= Sequential
+	}
+	c.code.reset()
+	return access
+}
+
+func (c *CPU) read8(address uint32) uint8 {
+	return c.bus.Read8(address, c.dataAccess(address, 1))
+}
+
+func (c *CPU) read16(address uint32) uint16 {
+	return c.bus.Read16(address, c.dataAccess(address, 2))
+}
+
+func (c *CPU) read32(address uint32) uint32 {
+	return c.bus.Read32(address, c.dataAccess(address, 4))
+}
+
+func (c *CPU) write8(address uint32, value uint8) {
+	c.bus.Write8(address, value, c.dataAccess(address, 1))
+}
+
+func (c *CPU) write16(address uint32, value uint16) {
+	c.bus.Write16(address, value, c.dataAccess(address, 2))
+}
+
+func (c *CPU) write32(address uint32, value uint32) {
+	c.bus.Write32(address, value, c.dataAccess(address, 4))
+}
+
+func (c *CPU) instructionSize() uint32 {
+	switch c.cpsrState() {
+	case 1:
+		return 2
+	default:
+		return 4
+	}
+}
+
 func (c *CPU) pcInc() {
 	switch c.cpsrState() {
 	case 0:
@@ -31,39 +128,109 @@ func (c *CPU) pcInc() {
 	}
 }
 
-func (c *CPU) Step() {
-	curr := c.curr
+// Step executes the instruction at the head of the pipeline, or takes an
+// interrupt. Once the CPU has faulted, Step does nothing and returns the
+// fault.
+func (c *CPU) Step() error {
+	if c.fault != nil {
+		return c.fault
+	}
 
-	switch c.cpsrState() {
-	case 0:
-		curr &= ^uint32(3)
-		instruction := c.Memory.Read32(curr, true, false)
-		c.Arm(instruction)
-	case 1:
-		curr &= ^uint32(1)
-		instruction := c.Memory.Read16(curr, true, false)
-		c.Thumb(uint32(instruction))
+	if c.cpsrIRQDisable() == 0 && c.irq.IRQ() {
+		c.exception(0x18)
+		c.flushed = false
+		return c.Err()
+	}
+
+	// the fetch that happens while an instruction executes is the one for the
+	// instruction two ahead of it, at R15
+	size := c.instructionSize()
+	fetched := c.fetch(c.R[15]&^(size-1), size)
+	c.record()
+
+	switch size {
+	case 4:
+		c.Arm(c.pipeline[0])
+	case 2:
+		c.Thumb(c.pipeline[0])
 	}
 
 	if !c.flushed {
 		c.curr = c.next
 		c.next = c.R[15]
+		c.pipeline = [2]uint32{c.pipeline[1], fetched}
 
 		c.pcInc()
 	}
 	c.flushed = false
+
+	return c.Err()
+}
+
+// undefined takes the undefined instruction trap, or stops the CPU when
+// running in strict mode.
+func (c *CPU) undefined(instruction uint32) {
+	if c.Strict {
+		c.fail(&UndefinedError{
+			Opcode: instruction,
+			Thumb:  c.cpsrState() == 1,
+		})
+		return
+	}
+
+	c.exception(0x04)
 }
 
-func noins(instruction uint32) {
-	panic(fmt.Sprintf("nothing to do for: %032b", instruction))
+// halt stops instruction execution until an interrupt enabled in IE is
+// requested. In stop mode the timers and display sleep as well.
+func (c *CPU) halt(stop bool) {
+	c.halted = true
+	c.stopped = stop
 }
 
+func (c *CPU) wake() {
+	c.halted = false
+	c.stopped = false
+}
+
+// prefetchFlush refills the pipeline from R15 after a write to it. The
+// low bits of the new PC are ignored, as the fetch ignores them.
 func (c *CPU) prefetchFlush() {
+	c.R[15] &^= c.instructionSize() - 1
 	c.curr = c.R[15]
 	c.pcInc()
 	c.next = c.R[15]
 	c.pcInc()
 	c.flushed = true
+
+	// the refill starts a new code stream even when the target follows on
+	c.code.reset()
+	size := c.instructionSize()
+	c.pipeline[0] = c.fetch(c.curr&^(size-1), size)
+	c.pipeline[1] = c.fetch(c.next&^(size-1), size)
+}
+
+// readWord loads a word as LDR does. A misaligned address reads the aligned
+// word rotated right so the addressed byte lands in the low byte.
+func (c *CPU) readWord(addr uint32) uint32 {
+	value, _ := ShiftROR(c.read32(addr), (addr&3)*8)
+	return value
+}
+
+// readHalf loads a halfword as LDRH does. A misaligned address reads the
+// aligned halfword rotated right by a byte.
+func (c *CPU) readHalf(addr uint32) uint32 {
+	value, _ := ShiftROR(uint32(c.read16(addr)), (addr&1)*8)
+	return value
+}
+
+// readHalfSigned loads a halfword as LDRSH does. A misaligned address
+// sign-extends the addressed byte instead.
+func (c *CPU) readHalfSigned(addr uint32) uint32 {
+	if addr&1 == 1 {
+		return uint32(signify(uint32(c.read8(addr)), 8))
+	}
+	return uint32(signify(uint32(c.read16(addr)), 16))
 }
 
 type CPURegisters struct {
@@ -71,130 +238,53 @@ type CPURegisters struct {
 	R    [16]uint32
 	CPSR uint32
 
-	// registers to be swapped on mode change
-	R0       uint32
-	R1       uint32
-	R2       uint32
-	R3       uint32
-	R4       uint32
-	R5       uint32
-	R6       uint32
-	R7       uint32
-	R8       uint32
-	R9       uint32
-	R10      uint32
-	R11      uint32
-	R12      uint32
-	R13      uint32
-	R14      uint32
-	R15      uint32
-	R8_fiq   uint32
-	R9_fiq   uint32
-	R10_fiq  uint32
-	R11_fiq  uint32
-	R12_fiq  uint32
-	R13_fiq  uint32
-	R13_svc  uint32
-	R13_abt  uint32
-	R13_irq  uint32
-	R13_und  uint32
-	R14_fiq  uint32
-	R14_svc  uint32
-	R14_abt  uint32
-	R14_irq  uint32
-	R14_und  uint32
-	SPSR_fiq uint32
-	SPSR_svc uint32
-	SPSR_abt uint32
-	SPSR_irq uint32
-	SPSR_und uint32
+	// R8-R14 and the SPSR of each register bank, swapped with R on mode
+	// change. R8-R12 are only banked for FIQ, every other mode keeps them in
+	// the USR bank.
+	Banked [6][7]uint32
+	SPSR   [6]uint32
+}
+
+const (
+	bankUSR = iota
+	bankFIQ
+	bankIRQ
+	bankSVC
+	bankABT
+	bankUND
+)
+
+var modeBank = [32]uint8{
+	USR & 0x1F: bankUSR,
+	FIQ & 0x1F: bankFIQ,
+	IRQ & 0x1F: bankIRQ,
+	SVC & 0x1F: bankSVC,
+	ABT & 0x1F: bankABT,
+	UND & 0x1F: bankUND,
+	SYS & 0x1F: bankUSR,
 }
 
+// registerAddr returns where register r is stored for mode while another
+// mode is active. Only R8-R14 are banked.
 func (c *CPU) registerAddr(mode uint32, r uint32) *uint32 {
-	return map[uint32]*uint32{
-		0: &c.R0,
-		1: &c.R1,
-		2: &c.R2,
-		3: &c.R3,
-		4: &c.R4,
-		5: &c.R5,
-		6: &c.R6,
-		7: &c.R7,
-		8: map[uint32]*uint32{
-			USR: &c.R8,
-			FIQ: &c.R8_fiq,
-			IRQ: &c.R8,
-			SVC: &c.R8,
-			ABT: &c.R8,
-			UND: &c.R8,
-			SYS: &c.R8,
-		}[mode],
-		9: map[uint32]*uint32{
-			USR: &c.R9,
-			FIQ: &c.R9_fiq,
-			IRQ: &c.R9,
-			SVC: &c.R9,
-			ABT: &c.R9,
-			UND: &c.R9,
-			SYS: &c.R9,
-		}[mode],
-		10: map[uint32]*uint32{
-			USR: &c.R10,
-			FIQ: &c.R10_fiq,
-			IRQ: &c.R10,
-			SVC: &c.R10,
-			ABT: &c.R10,
-			UND: &c.R10,
-			SYS: &c.R10,
-		}[mode],
-		11: map[uint32]*uint32{
-			USR: &c.R11,
-			FIQ: &c.R11_fiq,
-			IRQ: &c.R11,
-			SVC: &c.R11,
-			ABT: &c.R11,
-			UND: &c.R11,
-			SYS: &c.R11,
-		}[mode],
-		12: map[uint32]*uint32{
-			USR: &c.R12,
-			FIQ: &c.R12_fiq,
-			IRQ: &c.R12,
-			SVC: &c.R12,
-			ABT: &c.R12,
-			UND: &c.R12,
-			SYS: &c.R12,
-		}[mode],
-		13: map[uint32]*uint32{
-			USR: &c.R13,
-			FIQ: &c.R13_fiq,
-			IRQ: &c.R13_irq,
-			SVC: &c.R13_svc,
-			ABT: &c.R13_abt,
-			UND: &c.R13_und,
-			SYS: &c.R13,
-		}[mode],
-		14: map[uint32]*uint32{
-			USR: &c.R14,
-			FIQ: &c.R14_fiq,
-			IRQ: &c.R14_irq,
-			SVC: &c.R14_svc,
-			ABT: &c.R14_abt,
-			UND: &c.R14_und,
-			SYS: &c.R14,
-		}[mode],
-		15: &c.R15,
-	}[r]
+	bank := modeBank[mode&0x1F]
+	if r < 13 && bank != bankFIQ {
+		bank = bankUSR
+	}
+	return &c.Banked[bank][r-8]
+}
+
+// bankedRegister returns register r of mode, whether it is live in R or
+// stored away in its bank.
+func (c *CPU) bankedRegister(mode, r uint32) *uint32 {
+	if r < 8 || r == 15 || c.registerAddr(c.cpsrMode(), r) == c.registerAddr(mode, r) {
+		return &c.R[r]
+	}
+	return c.registerAddr(mode, r)
 }
 
 func (c *CPU) spsrAddr(mode uint32) *uint32 {
-	return map[uint32]*uint32{
-		FIQ: &c.SPSR_fiq,
-		IRQ: &c.SPSR_irq,
-		SVC: &c.SPSR_svc,
-		ABT: &c.SPSR_abt,
-		UND: &c.SPSR_und,
-	}[mode]
+	return &c.SPSR[modeBank[mode&0x1F]]
 }
 
 const (
@@ -207,11 +297,27 @@ const (
 	SYS uint32 = 0b11111
 )
 
+// hasSPSR reports whether the current mode has an SPSR. USR and SYS do
+// not, and their SPSR reads as CPSR and ignores writes.
+func (c *CPU) hasSPSR() bool {
+	mode := c.cpsrMode() | 0b10000
+	return mode != USR && mode != SYS
+}
+
+// restoreCpsr copies SPSR to CPSR, as returning from an exception does. In a
+// mode without an SPSR it leaves CPSR alone.
 func (c *CPU) restoreCpsr() {
-	cpsr := *c.spsrAddr(c.cpsrMode())
-	newMode := ReadBits(cpsr, 0, 5)
-	c.cpsrSetMode(newMode)
-	c.CPSR = cpsr
+	if c.hasSPSR() {
+		c.setCPSR(*c.spsrAddr(c.cpsrMode()))
+	}
+}
+
+// setCPSR writes the whole of CPSR, switching register banks for the mode
+// it holds.
+func (c *CPU) setCPSR(value uint32) {
+	value |= 0b10000
+	c.cpsrSetMode(ReadBits(value, 0, 5))
+	c.CPSR = value
 }
 
 func (c *CPU) cpsrMode() uint32 {
@@ -222,21 +328,21 @@ func (c *CPU) cpsrInitMode(value uint32) {
 	c.CPSR = SetBits(c.CPSR, 0, 5, value)
 }
 
+// cpsrSetMode switches to another mode, swapping in its banked registers.
+// The rest of CPSR and every SPSR are left alone.
 func (c *CPU) cpsrSetMode(value uint32) {
 	prevMode := c.cpsrMode() | 0b10000
 	nextMode := value | 0b10000
 
-	wasPrivileged := prevMode != USR && prevMode != SYS
-	nowPrivileged := nextMode != USR && nextMode != SYS
+	prevBank := modeBank[prevMode&0x1F]
+	nextBank := modeBank[nextMode&0x1F]
 
-	if nowPrivileged {
-		*c.spsrAddr(nextMode) = c.CPSR
-	}
-	if wasPrivileged {
-		c.CPSR = *c.spsrAddr(prevMode)
+	first := uint32(13)
+	if (prevBank == bankFIQ) != (nextBank == bankFIQ) {
+		first = 8
 	}
 
-	for i := uint32(0); i <= 15; i++ {
+	for i := first; i <= 14; i++ {
 		*c.registerAddr(prevMode, i) = c.R[i]
 		c.R[i] = *c.registerAddr(nextMode, i)
 	}
@@ -301,26 +407,41 @@ func (c *CPU) cpsrSetV(value bool) {
 }
 
 func (c *CPU) exception(vector uint32) {
+	var lr uint32
+	switch vector {
+	case 0x04, 0x08: // return to the next instruction
+		lr = c.curr + c.instructionSize()
+	case 0x18, 0x1C: // return with SUBS PC, LR, #4
+		lr = c.curr + 4
+	default:
+		lr = c.R[15]
+	}
+
+	var mode uint32
 	switch vector {
 	case 0x00: // reset
-		c.cpsrSetMode(SVC)
+		mode = SVC
 	case 0x04: // undefined
-		c.cpsrSetMode(UND)
+		mode = UND
 	case 0x08: // swi
-		c.cpsrSetMode(SVC)
+		mode = SVC
 	case 0x0C: // prefetch abort
-		c.cpsrSetMode(ABT)
+		mode = ABT
 	case 0x10: // data
-		c.cpsrSetMode(ABT)
+		mode = ABT
 	case 0x14: // address exceed
-		c.cpsrSetMode(SVC)
+		mode = SVC
 	case 0x18: // irq
-		c.cpsrSetMode(IRQ)
+		mode = IRQ
 	case 0x1C: // fiq
-		c.cpsrSetMode(FIQ)
+		mode = FIQ
 	}
 
-	c.R[14] = c.R[15]
+	cpsr := c.CPSR
+	c.cpsrSetMode(mode)
+	*c.spsrAddr(mode) = cpsr
+
+	c.R[14] = lr
 	c.cpsrSetState(0)
 	c.cpsrSetIRQDisable(1)
 	switch vector {
@@ -375,20 +496,37 @@ func (c *CPU) cond(cond uint32) bool {
 }
 
 const (
-	SoftReset        uint32 = 0x00
-	RegisterRamReset uint32 = 0x01
-	CpuSet           uint32 = 0x0B
+	SoftReset            uint32 = 0x00
+	RegisterRamReset     uint32 = 0x01
+	Div                  uint32 = 0x06
+	DivArm               uint32 = 0x07
+	Sqrt                 uint32 = 0x08
+	ArcTan               uint32 = 0x09
+	ArcTan2              uint32 = 0x0A
+	CpuSet               uint32 = 0x0B
+	CpuFastSet           uint32 = 0x0C
+	BgAffineSet          uint32 = 0x0E
+	ObjAffineSet         uint32 = 0x0F
+	BitUnPack            uint32 = 0x10
+	LZ77UnCompWram       uint32 = 0x11
+	LZ77UnCompVram       uint32 = 0x12
+	HuffUnComp           uint32 = 0x13
+	RLUnCompWram         uint32 = 0x14
+	RLUnCompVram         uint32 = 0x15
+	Diff8bitUnFilterWram uint32 = 0x16
+	Diff8bitUnFilterVram uint32 = 0x17
+	Diff16bitUnFilter    uint32 = 0x18
 )
 
 func (c *CPU) SWI(comment uint32) {
 	switch comment {
 	case SoftReset:
-		c.R13 = 0x03007F00
-		c.R13_svc = 0x03007FE0
-		c.R13_irq = 0x03007FA0
-		flag := c.Memory.Read8(0x3007FFA, true, false)
+		*c.registerAddr(USR, 13) = 0x03007F00
+		*c.registerAddr(SVC, 13) = 0x03007FE0
+		*c.registerAddr(IRQ, 13) = 0x03007FA0
+		flag := c.read8(0x3007FFA)
 		for i := uint32(0x3007E00); i <= 0x3007FFF; i++ {
-			c.Memory.Set8(i, 0, true, false) // todo: replace with clear
+			c.write8(i, 0) // todo: replace with clear
 		}
 		if flag == 0 {
 			c.R[14] = 0x08000000
@@ -410,81 +548,81 @@ func (c *CPU) SWI(comment uint32) {
 		case fill == 0 && datasize == 0:
 			for i := uint32(0); i < count; i++ {
 				offset := i << 1
-				value := c.Memory.Read16(source+offset, true, false)
-				c.Memory.Set16(destination+offset, value, true, false)
+				value := c.read16(source + offset)
+				c.write16(destination+offset, value)
 			}
 		case fill == 0 && datasize == 1:
 			for i := uint32(0); i < count; i++ {
 				offset := i << 2
-				value := c.Memory.Read32(source+offset, true, false)
-				c.Memory.Set32(destination+offset, value, true, false)
+				value := c.read32(source + offset)
+				c.write32(destination+offset, value)
 			}
 		case fill == 1 && datasize == 0:
-			value := c.Memory.Read16(source, true, false)
+			value := c.read16(source)
 			for i := uint32(0); i < count; i++ {
 				offset := i << 1
-				c.Memory.Set16(destination+offset, value, true, false)
+				c.write16(destination+offset, value)
 			}
 		case fill == 1 && datasize == 1:
-			value := c.Memory.Read32(source, true, false)
+			value := c.read32(source)
 			for i := uint32(0); i < count; i++ {
 				offset := i << 2
-				c.Memory.Set32(destination+offset, value, true, false)
+				c.write32(destination+offset, value)
 			}
 		}
+	case CpuFastSet:
+		c.cpuFastSet()
+	case BgAffineSet:
+		c.bgAffineSet()
+	case ObjAffineSet:
+		c.objAffineSet()
+	case BitUnPack:
+		c.bitUnPack()
+	case Diff8bitUnFilterWram:
+		c.diff8bitUnFilterWram()
+	case Diff8bitUnFilterVram:
+		c.diff8bitUnFilterVram()
+	case Diff16bitUnFilter:
+		c.diff16bitUnFilter()
+	case Div:
+		c.div(c.R[0], c.R[1])
+	case DivArm:
+		c.div(c.R[1], c.R[0])
+	case Sqrt:
+		c.R[0] = c.sqrt(c.R[0])
+	case ArcTan:
+		c.R[0] = uint32(c.arcTan(int32(c.R[0])))
+	case ArcTan2:
+		c.R[0] = c.arcTan2(int32(c.R[0]), int32(c.R[1]))
+	case LZ77UnCompWram:
+		c.lz77UnCompWram()
+	case LZ77UnCompVram:
+		c.lz77UnCompVram()
+	case HuffUnComp:
+		c.huffUnComp()
+	case RLUnCompWram:
+		c.rlUnCompWram()
+	case RLUnCompVram:
+		c.rlUnCompVram()
 	case RegisterRamReset:
+		// always left to the BIOS, strict or not: it also resets the sound
+		// and serial registers, and must spare the top of IWRAM
 		c.exception(0x08)
-		return
-
-		clearWram1 := ReadBits(c.R[0], 0, 1)
-		if clearWram1 == 1 {
-			c.Memory.ClearBlock(WRAM1)
-		}
-
-		clearWram2 := ReadBits(c.R[0], 1, 1)
-		if clearWram2 == 1 {
-			c.Memory.ClearBlock(WRAM2)
-		}
-
-		clearPalette := ReadBits(c.R[0], 2, 1)
-		if clearPalette == 1 {
-			c.Memory.ClearBlock(Palette)
-		}
-
-		clearVRAM := ReadBits(c.R[0], 3, 1)
-		if clearVRAM == 1 {
-			c.Memory.ClearBlock(VRAM)
-		}
-
-		clearOAM := ReadBits(c.R[0], 4, 1)
-		if clearOAM == 1 {
-			c.Memory.ClearBlock(OAM)
-		}
-
-		resetSIO := ReadBits(c.R[0], 5, 1)
-		if resetSIO == 1 {
-			_ = ""
-			// todo
-		}
-
-		resetSound := ReadBits(c.R[0], 6, 1)
-		if resetSound == 1 {
-			_ = ""
-			// todo
-		}
-
-		resetOther := ReadBits(c.R[0], 7, 1)
-		if resetOther == 1 {
-			_ = ""
-			// todo
-		}
-
-		SetIORegister(c.Memory, DISPCNT, 0x0080)
 	default:
-		noComment(comment)
+		c.biosSWI(comment)
 	}
 }
 
-func noComment(comment uint32) {
-	panic(fmt.Sprintf("nothing to do for comment: 0x%02x", comment))
+// biosSWI hands a SWI without a high level implementation to the BIOS, or
+// stops the CPU when running in strict mode.
+func (c *CPU) biosSWI(comment uint32) {
+	if c.Strict {
+		c.fail(&UndefinedError{
+			Opcode: comment,
+			SWI:    true,
+		})
+		return
+	}
+
+	c.exception(0x08)
 }
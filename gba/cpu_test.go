@@ -0,0 +1,759 @@
+package gba
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dbut2/sapphire/asm"
+)
+
+// TestModeSwitchAllocs expects switching banks, whether directly or by
+// taking an exception, never to allocate.
+func TestModeSwitchAllocs(t *testing.T) {
+	m := NewMotherboard(nil)
+	c := m.CPU
+	c.cpsrInitMode(SYS)
+
+	switches := func() {
+		c.cpsrSetMode(IRQ)
+		c.cpsrSetMode(FIQ)
+		c.cpsrSetMode(SVC)
+		c.cpsrSetMode(SYS)
+	}
+	if allocs := testing.AllocsPerRun(100, switches); allocs != 0 {
+		t.Errorf("mode switch allocated %v times, want 0", allocs)
+	}
+
+	exception := func() {
+		c.exception(0x18)
+		c.cpsrSetMode(SYS)
+	}
+	if allocs := testing.AllocsPerRun(100, exception); allocs != 0 {
+		t.Errorf("exception allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkModeSwitch(b *testing.B) {
+	m := NewMotherboard(nil)
+	c := m.CPU
+	c.cpsrInitMode(SYS)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.cpsrSetMode(IRQ)
+		c.cpsrSetMode(FIQ)
+		c.cpsrSetMode(SVC)
+		c.cpsrSetMode(SYS)
+	}
+}
+
+// TestMultiply checks the products, the flags and the early termination of
+// the multiplier array, which stops once the rest of the multiplier is all
+// zeros or, for signed multiplies, all ones. Each takes a cycle to fetch from
+// IWRAM on top of its internal cycles.
+func TestMultiply(t *testing.T) {
+	tests := []struct {
+		source         string
+		thumb          bool
+		r0, r1, r2, r3 uint32
+		flags          uint32 // NZCV before
+		want0, want1   uint32
+		wantFlags      uint32
+		cycles         uint32
+	}{
+		{"mla r0, r1, r2, r3", false, 0, 0xFFFFFFFD, 7, 100, 0x0, 79, 0xFFFFFFFD, 0x0, 3},
+		{"mla r0, r1, r2, r3", false, 0, 5, 0xFFFFFFFE, 3, 0x0, 0xFFFFFFF9, 5, 0x0, 3},
+		{"muls r0, r1, r2", false, 0, 0x10000, 0x10000, 0, 0x3, 0, 0x10000, 0x7, 4},
+		{"muls r0, r1, r2", false, 0, 0xFFFFFFFF, 0x12345678, 0, 0x0, 0xEDCBA988, 0xFFFFFFFF, 0x8, 5},
+		{"umull r0, r1, r2, r3", false, 0, 0, 0xFFFFFFFF, 0xFFFFFFFF, 0x0, 0x00000001, 0xFFFFFFFE, 0x0, 6},
+		{"umlal r0, r1, r2, r3", false, 1, 2, 0xFFFFFFFF, 2, 0x0, 0xFFFFFFFF, 3, 0x0, 4},
+		{"smull r0, r1, r2, r3", false, 0, 0, 0xFFFFFFFD, 0xFFFFFFFE, 0x0, 6, 0, 0x0, 3},
+		{"smull r0, r1, r2, r3", false, 0, 0, 7, 0xFFFF0000, 0x0, 0xFFF90000, 0xFFFFFFFF, 0x0, 4},
+		{"smulls r0, r1, r2, r3", false, 0, 0, 0xFFFFFFFF, 1, 0x3, 0xFFFFFFFF, 0xFFFFFFFF, 0xB, 3},
+		{"smlals r0, r1, r2, r3", false, 0x10, 0, 0xFFFFFFFF, 0x10, 0x8, 0, 0, 0x4, 4},
+		{"muls r0, r1", true, 0xFFFFFFFE, 3, 0, 0, 0x3, 0xFFFFFFFA, 3, 0xB, 2},
+		{"muls r0, r1", true, 0x01000000, 0x100, 0, 0, 0x0, 0, 0x100, 0x4, 5},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %08X %08X %08X %08X", tt.source, tt.r0, tt.r1, tt.r2, tt.r3)
+		c := loadSource(tt.source, tt.thumb, func(c *CPU) {
+			c.R[0], c.R[1], c.R[2], c.R[3] = tt.r0, tt.r1, tt.r2, tt.r3
+			c.CPSR |= tt.flags << 28
+		}).CPU
+		before := c.cycles
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.R[0] != tt.want0 || c.R[1] != tt.want1 {
+			t.Errorf("%s: r0, r1 = %08X, %08X, want %08X, %08X", name, c.R[0], c.R[1], tt.want0, tt.want1)
+		}
+		if got := c.CPSR >> 28; got != tt.wantFlags {
+			t.Errorf("%s: NZCV = %04b, want %04b", name, got, tt.wantFlags)
+		}
+		if got := c.cycles - before; got != tt.cycles {
+			t.Errorf("%s: took %d cycles, want %d", name, got, tt.cycles)
+		}
+	}
+}
+
+func TestMisalignedLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction uint32
+		thumb       bool
+		offset      uint32
+		want        uint32
+	}{
+		{"LDR", 0xE5910000, false, 0, 0x8899AABB},
+		{"LDR+1", 0xE5910000, false, 1, 0xBB8899AA},
+		{"LDR+2", 0xE5910000, false, 2, 0xAABB8899},
+		{"LDR+3", 0xE5910000, false, 3, 0x99AABB88},
+		{"LDRH", 0xE1D100B0, false, 0, 0x0000AABB},
+		{"LDRH+1", 0xE1D100B0, false, 1, 0xBB0000AA},
+		{"LDRSH", 0xE1D100F0, false, 0, 0xFFFFAABB},
+		{"LDRSH+1", 0xE1D100F0, false, 1, 0xFFFFFFAA},
+		{"LDRSH+3", 0xE1D100F0, false, 3, 0xFFFFFF88},
+		{"SWP+1", 0xE1010092, false, 1, 0xBB8899AA},
+		{"thumb LDR+2", 0x6808, true, 2, 0xAABB8899},
+		{"thumb LDR reg+3", 0x5888, true, 3, 0x99AABB88},
+		{"thumb LDR SP+1", 0x9800, true, 1, 0xBB8899AA},
+		{"thumb LDRH+3", 0x8808, true, 3, 0x99000088},
+		{"thumb LDRH reg+1", 0x5A88, true, 1, 0xBB0000AA},
+		{"thumb LDSH reg+1", 0x5E88, true, 1, 0xFFFFFFAA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMotherboard(nil)
+			c := m.CPU
+			c.cpsrInitMode(SYS)
+
+			base := WRAM2.Start + 0x100
+			m.Memory.Set32(base, 0x8899AABB, false, false)
+
+			c.R[1] = base + tt.offset
+			c.R[13] = base + tt.offset
+			if tt.thumb {
+				c.cpsrSetState(1)
+				c.Thumb(tt.instruction)
+			} else {
+				c.Arm(tt.instruction)
+			}
+
+			if c.R[0] != tt.want {
+				t.Errorf("r0 = %08X, want %08X", c.R[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockTransfer(t *testing.T) {
+	base := WRAM2.Start + 0x100
+	pc := WRAM2.Start
+
+	irq := func(c *CPU) {
+		c.R[13] = 0x03007F00
+		c.cpsrSetMode(IRQ)
+		c.R[13] = base
+		*c.spsrAddr(IRQ) = 0x6000001F
+	}
+
+	tests := []struct {
+		name        string
+		instruction uint32
+		thumb       bool
+		setup       func(c *CPU)
+		memory      map[uint32]uint32
+		want        map[uint32]uint32 // memory after
+		check       func(t *testing.T, c *CPU)
+	}{
+		{
+			name:        "STMIA empty",
+			instruction: 0xE8A00000, // STMIA r0!, {}
+			want:        map[uint32]uint32{base: pc + 12},
+			check: func(t *testing.T, c *CPU) {
+				if c.R[0] != base+0x40 {
+					t.Errorf("r0 = %08X, want %08X", c.R[0], base+0x40)
+				}
+			},
+		},
+		{
+			name:        "LDMDB empty",
+			instruction: 0xE9300000, // LDMDB r0!, {}
+			memory:      map[uint32]uint32{base - 0x40: 0x08000200},
+			check: func(t *testing.T, c *CPU) {
+				if c.R[0] != base-0x40 || c.curr != 0x08000200 {
+					t.Errorf("r0 = %08X, pc = %08X, want %08X, 08000200", c.R[0], c.curr, base-0x40)
+				}
+			},
+		},
+		{
+			name:        "STM first base",
+			instruction: 0xE8A00003, // STMIA r0!, {r0, r1}
+			want:        map[uint32]uint32{base: base},
+		},
+		{
+			name:        "STM later base",
+			instruction: 0xE8A10003, // STMIA r1!, {r0, r1}
+			setup:       func(c *CPU) { c.R[1] = base },
+			want:        map[uint32]uint32{base + 4: base + 8},
+		},
+		{
+			name:        "LDM base",
+			instruction: 0xE8B00003, // LDMIA r0!, {r0, r1}
+			memory:      map[uint32]uint32{base: 0x11111111, base + 4: 0x22222222},
+			check: func(t *testing.T, c *CPU) {
+				if c.R[0] != 0x11111111 || c.R[1] != 0x22222222 {
+					t.Errorf("r0, r1 = %08X, %08X, want 11111111, 22222222", c.R[0], c.R[1])
+				}
+			},
+		},
+		{
+			name:        "STMDB order",
+			instruction: 0xE9200006, // STMDB r0!, {r1, r2}
+			setup:       func(c *CPU) { c.R[1], c.R[2] = 1, 2 },
+			want:        map[uint32]uint32{base - 8: 1, base - 4: 2},
+		},
+		{
+			name:        "STM user bank",
+			instruction: 0xE8C06000, // STMIA r0, {sp, lr}^
+			setup:       irq,
+			want:        map[uint32]uint32{base: 0x03007F00},
+		},
+		{
+			name:        "LDM user bank",
+			instruction: 0xE8D02000, // LDMIA r0, {sp}^
+			setup:       irq,
+			memory:      map[uint32]uint32{base: 0x03001234},
+			check: func(t *testing.T, c *CPU) {
+				if c.R[13] != base || *c.bankedRegister(USR, 13) != 0x03001234 {
+					t.Errorf("sp_irq, sp_usr = %08X, %08X, want %08X, 03001234", c.R[13], *c.bankedRegister(USR, 13), base)
+				}
+			},
+		},
+		{
+			name:        "IRQ return",
+			instruction: 0xE8FD8001, // LDMFD sp!, {r0, pc}^
+			setup:       irq,
+			memory:      map[uint32]uint32{base: 0x1234, base + 4: 0x08000200},
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x6000001F || c.curr != 0x08000200 || c.R[0] != 0x1234 {
+					t.Errorf("cpsr = %08X, pc = %08X, r0 = %08X, want 6000001F, 08000200, 00001234", c.CPSR, c.curr, c.R[0])
+				}
+				if c.R[13] != 0x03007F00 || *c.registerAddr(IRQ, 13) != base+8 {
+					t.Errorf("sp, sp_irq = %08X, %08X, want 03007F00, %08X", c.R[13], *c.registerAddr(IRQ, 13), base+8)
+				}
+			},
+		},
+		{
+			name:        "PUSH empty",
+			instruction: 0xB400, // PUSH {}
+			thumb:       true,
+			setup:       func(c *CPU) { c.R[13] = base },
+			want:        map[uint32]uint32{base - 0x40: pc + 6},
+		},
+		{
+			name:        "POP empty",
+			instruction: 0xBC00, // POP {}
+			thumb:       true,
+			setup:       func(c *CPU) { c.R[13] = base },
+			memory:      map[uint32]uint32{base: 0x08000200},
+			check: func(t *testing.T, c *CPU) {
+				if c.R[13] != base+0x40 || c.curr != 0x08000200 {
+					t.Errorf("sp = %08X, pc = %08X, want %08X, 08000200", c.R[13], c.curr, base+0x40)
+				}
+			},
+		},
+		{
+			name:        "thumb STMIA later base",
+			instruction: 0xC103, // STMIA r1!, {r0, r1}
+			thumb:       true,
+			setup:       func(c *CPU) { c.R[1] = base },
+			want:        map[uint32]uint32{base + 4: base + 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMotherboard(nil)
+			c := m.CPU
+			c.cpsrInitMode(SYS)
+
+			for address, value := range tt.memory {
+				m.Memory.Set32(address, value, false, false)
+			}
+
+			c.R[0] = base
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+
+			c.curr = pc
+			if tt.thumb {
+				c.cpsrSetState(1)
+				c.R[15] = pc + 4
+				c.Thumb(tt.instruction)
+			} else {
+				c.R[15] = pc + 8
+				c.Arm(tt.instruction)
+			}
+
+			for address, want := range tt.want {
+				if got := m.Memory.Read32(address, false, false); got != want {
+					t.Errorf("[%08X] = %08X, want %08X", address, got, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, c)
+			}
+		})
+	}
+}
+
+func TestPipelinePC(t *testing.T) {
+	org := WRAM2.Start
+	base := WRAM2.Start + 0x100
+
+	tests := []struct {
+		name   string
+		source string
+		thumb  bool
+		setup  func(c *CPU)
+		steps  int
+		r0     uint32
+		memory uint32 // word at base after
+	}{
+		{
+			name:   "operand",
+			source: "mov r0, pc",
+			steps:  1,
+			r0:     org + 8,
+		},
+		{
+			name:   "register shift Rn",
+			source: "mov r2, #0\nadd r0, pc, r2, lsl r2",
+			steps:  2,
+			r0:     org + 4 + 12,
+		},
+		{
+			name:   "register shift Rm",
+			source: "mov r2, #0\nmov r0, pc, lsl r2",
+			steps:  2,
+			r0:     org + 4 + 12,
+		},
+		{
+			name:   "STR",
+			source: "str pc, [r1]",
+			steps:  1,
+			memory: org + 12,
+		},
+		{
+			name:   "STRH",
+			source: "strh pc, [r1]",
+			steps:  1,
+			memory: (org + 12) & 0xFFFF,
+		},
+		{
+			name:   "STM",
+			source: "stmia r1, {pc}",
+			steps:  1,
+			memory: org + 12,
+		},
+		{
+			name:   "LDR",
+			source: "ldr pc, =target\nmov r0, #2\ntarget: mov r0, #1\n.pool",
+			steps:  2,
+			r0:     1,
+		},
+		{
+			name:   "LDR misaligned target",
+			source: "ldr pc, =target + 2\nmov r0, #2\ntarget: mov r0, #1\n.pool",
+			steps:  2,
+			r0:     1,
+		},
+		{
+			name:   "MOVS",
+			source: "movs pc, lr\n.thumb\nnop\nmov r0, pc",
+			setup: func(c *CPU) {
+				c.cpsrSetMode(SVC)
+				*c.spsrAddr(SVC) = SYS | 1<<5
+				c.R[14] = org + 4
+			},
+			steps: 3,
+			r0:    org + 6 + 4,
+		},
+		{
+			name:   "thumb operand",
+			source: "mov r0, pc",
+			thumb:  true,
+			steps:  1,
+			r0:     org + 4,
+		},
+		{
+			name:   "thumb ADD PC",
+			source: "nop\nadd r0, pc, #0",
+			thumb:  true,
+			steps:  2,
+			r0:     org + 4,
+		},
+		{
+			name:   "thumb LDR PC",
+			source: "nop\nldr r0, [pc, #0]\n.word 0x12345678",
+			thumb:  true,
+			steps:  2,
+			r0:     0x12345678,
+		},
+		{
+			name:   "thumb MOV PC",
+			source: "mov pc, r1\nmovs r0, #2\n.align 4\nmovs r0, #1",
+			thumb:  true,
+			setup:  func(c *CPU) { c.R[1] = org + 0x11 },
+			steps:  2,
+			r0:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := loadSource(tt.source, tt.thumb, func(c *CPU) {
+				c.R[1] = base
+				if tt.setup != nil {
+					tt.setup(c)
+				}
+			})
+			c := m.CPU
+
+			for range tt.steps {
+				if err := c.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if c.R[0] != tt.r0 {
+				t.Errorf("r0 = %08X, want %08X", c.R[0], tt.r0)
+			}
+			if got := m.Memory.Read32(base, false, false); got != tt.memory {
+				t.Errorf("[%08X] = %08X, want %08X", base, got, tt.memory)
+			}
+		})
+	}
+}
+
+// loadSource assembles source into WRAM2 and starts the CPU on it in SYS
+// mode, after setup has had its say.
+func loadSource(source string, thumb bool, setup func(c *CPU)) *Motherboard {
+	m := NewMotherboard(nil)
+	c := m.CPU
+	c.cpsrInitMode(SYS)
+
+	if thumb {
+		source = ".thumb\n" + source
+		c.cpsrSetState(1)
+	}
+	for i, b := range asm.MustAssemble(source, WRAM2.Start) {
+		m.Memory.Set8(WRAM2.Start+uint32(i), b, false, false)
+	}
+
+	if setup != nil {
+		setup(c)
+	}
+	c.R[15] = WRAM2.Start
+	c.prefetchFlush()
+	c.flushed = false
+
+	return m
+}
+
+// TestUndefinedHalf expects the halfword encodings the ARM7TDMI lacks, such
+// as the later LDRD and STRD, to trap before changing any register.
+func TestUndefinedHalf(t *testing.T) {
+	org := WRAM2.Start
+
+	tests := []uint32{
+		0xE1C0F0F0, // strd r15, [r0]
+		0xE1C020D0, // ldrd r2, [r0]
+		0xE0C020F8, // strd r2, [r0], #8
+		0xE1B0F09F, // L=1 with opcode 0, writing back
+	}
+
+	for _, opcode := range tests {
+		c := loadSource(fmt.Sprintf(".word 0x%08X", opcode), false, func(c *CPU) {
+			c.CPSR |= 0x60000000
+			c.R[0] = WRAM1.Start
+		}).CPU
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.curr != 0x04 || c.cpsrMode() != UND {
+			t.Errorf("%08X: running %08X in mode %02X, want the undefined vector", opcode, c.curr, c.cpsrMode())
+		}
+		if c.R[14] != org+4 || *c.spsrAddr(UND) != 0x6000001F {
+			t.Errorf("%08X: lr_und, spsr_und = %08X, %08X, want %08X, 6000001F", opcode, c.R[14], *c.spsrAddr(UND), org+4)
+		}
+		if c.R[0] != WRAM1.Start || c.R[2] != 0 {
+			t.Errorf("%08X: r0, r2 = %08X, %08X, want them untouched", opcode, c.R[0], c.R[2])
+		}
+	}
+}
+
+// TestUndefinedOperation expects handlers that find an operation they lack
+// to leave the undefined vector as the trap left it.
+func TestUndefinedOperation(t *testing.T) {
+	tests := []struct {
+		name    string
+		thumb   bool
+		execute func(c *CPU)
+	}{
+		{"BXJ", false, func(c *CPU) { c.ArmBranchX(0xE12FFF20) }},
+		{"Thumb shift op 3", true, func(c *CPU) { c.ThumbShift(0x1888) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadSource("nop", tt.thumb, func(c *CPU) {
+				c.CPSR |= 0x60000000
+				c.R[0], c.R[1] = 0x12345678, 0x80000000
+			}).CPU
+			tt.execute(c)
+
+			if c.curr != 0x04 || c.cpsrMode() != UND {
+				t.Errorf("running %08X in mode %02X, want the undefined vector", c.curr, c.cpsrMode())
+			}
+			if c.R[0] != 0x12345678 || c.CPSR>>28 != 0x6 {
+				t.Errorf("r0, flags = %08X, %X, want them untouched", c.R[0], c.CPSR>>28)
+			}
+		})
+	}
+}
+
+// callSWI runs an ARM program calling SWI comment, handled by its high
+// level version or, with bios set, by the BIOS itself. It returns once the
+// call is back in the program.
+func callSWI(t *testing.T, comment uint32, bios bool, setup func(m *Motherboard)) *Motherboard {
+	t.Helper()
+
+	m := loadSource(fmt.Sprintf("svc %d\ndone:\tb done", comment<<16), false, func(c *CPU) {
+		*c.registerAddr(SVC, 13) = 0x03007FE0
+	})
+	setup(m)
+
+	c := m.CPU
+	if bios {
+		c.exception(0x08)
+	} else if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	for range 1 << 22 {
+		if c.curr == WRAM2.Start+4 {
+			return m
+		}
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("SWI %02X did not return", comment)
+	return nil
+}
+
+// compareSWI runs SWI comment through the BIOS and through its high level
+// version from the same setup, and expects the same output from destination
+// on, in about the same number of cycles: within a quarter either way.
+func compareSWI(t *testing.T, comment, destination uint32, setup func(m *Motherboard)) {
+	t.Helper()
+
+	output := func(m *Motherboard) []byte {
+		out := make([]byte, 0x400)
+		for i := range out {
+			out[i] = m.Memory.Read8(destination&^3+uint32(i), false, false)
+		}
+		return out
+	}
+
+	bios := callSWI(t, comment, true, setup)
+	hle := callSWI(t, comment, false, setup)
+	if want, got := output(bios), output(hle); !bytes.Equal(got, want) {
+		t.Errorf("output differs from the BIOS\ngot  %X\nwant %X", got, want)
+	}
+	if want, got := bios.CPU.cycles, hle.CPU.cycles; got < want*3/4 || got > want*5/4 {
+		t.Errorf("took %d cycles, the BIOS took %d", got, want)
+	}
+}
+
+func TestPSR(t *testing.T) {
+	org := WRAM2.Start
+
+	irq := func(c *CPU) {
+		c.R[13] = 0x03007F00
+		c.cpsrSetMode(IRQ)
+		c.R[13] = 0x03007FA0
+		*c.spsrAddr(IRQ) = 0x6000003F
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		setup  func(c *CPU)
+		steps  int
+		check  func(t *testing.T, c *CPU)
+	}{
+		{
+			name:   "MSR fields",
+			source: "msr cpsr_f, #0x80000000\nmsr cpsr_c, #0xD3",
+			steps:  2,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x800000D3 {
+					t.Errorf("cpsr = %08X, want 800000D3", c.CPSR)
+				}
+			},
+		},
+		{
+			name:   "MSR in USR",
+			source: "msr cpsr_fc, r1",
+			setup: func(c *CPU) {
+				c.cpsrSetMode(USR)
+				c.R[1] = 0xF00000DF
+			},
+			steps: 1,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0xF0000010 {
+					t.Errorf("cpsr = %08X, want F0000010", c.CPSR)
+				}
+			},
+		},
+		{
+			name:   "MSR keeps T",
+			source: "msr cpsr_c, #0x3F",
+			steps:  1,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x1F {
+					t.Errorf("cpsr = %08X, want 0000001F", c.CPSR)
+				}
+			},
+		},
+		{
+			name:   "MSR mode switch",
+			source: "msr cpsr_c, #0x92\nmov r0, sp",
+			setup: func(c *CPU) {
+				*c.registerAddr(IRQ, 13) = 0x03007FA0
+				*c.spsrAddr(IRQ) = 0x12345678
+			},
+			steps: 2,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x92 || c.R[0] != 0x03007FA0 || *c.spsrAddr(IRQ) != 0x12345678 {
+					t.Errorf("cpsr, sp, spsr = %08X, %08X, %08X, want 00000092, 03007FA0, 12345678", c.CPSR, c.R[0], *c.spsrAddr(IRQ))
+				}
+			},
+		},
+		{
+			name:   "SPSR in SYS",
+			source: "msr spsr_fc, r1\nmrs r0, spsr",
+			setup: func(c *CPU) {
+				c.CPSR |= 0x40000000
+				c.R[1] = 0xFFFFFFFF
+			},
+			steps: 2,
+			check: func(t *testing.T, c *CPU) {
+				if c.R[0] != 0x4000001F || *c.spsrAddr(SYS) != 0 {
+					t.Errorf("spsr = %08X, stored %08X, want 4000001F, 00000000", c.R[0], *c.spsrAddr(SYS))
+				}
+			},
+		},
+		{
+			name:   "MRS SPSR",
+			source: "msr spsr_f, #0x20000000\nmrs r0, spsr",
+			setup:  irq,
+			steps:  2,
+			check: func(t *testing.T, c *CPU) {
+				if c.R[0] != 0x2000003F {
+					t.Errorf("spsr = %08X, want 2000003F", c.R[0])
+				}
+			},
+		},
+		{
+			name:   "IRQ return",
+			source: "subs pc, lr, #4\n.thumb\nmovs r0, #1",
+			setup: func(c *CPU) {
+				irq(c)
+				c.R[14] = org + 8
+			},
+			steps: 2,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x2000003F || c.R[0] != 1 {
+					t.Errorf("cpsr, r0 = %08X, %08X, want 2000003F, 00000001", c.CPSR, c.R[0])
+				}
+				if c.R[13] != 0x03007F00 || *c.spsrAddr(IRQ) != 0x6000003F {
+					t.Errorf("sp, spsr_irq = %08X, %08X, want 03007F00, 6000003F", c.R[13], *c.spsrAddr(IRQ))
+				}
+			},
+		},
+		{
+			name:   "MOVS in SYS",
+			source: "movs pc, r1\nmov r0, #2\nmov r0, #1",
+			setup:  func(c *CPU) { c.R[1] = org + 8 },
+			steps:  2,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x1F || c.R[0] != 1 {
+					t.Errorf("cpsr, r0 = %08X, %08X, want 0000001F, 00000001", c.CPSR, c.R[0])
+				}
+			},
+		},
+		{
+			name:   "nested exception",
+			source: ".word 0xE7F000F0",
+			setup:  irq,
+			steps:  1,
+			check: func(t *testing.T, c *CPU) {
+				if c.CPSR != 0x9B || *c.spsrAddr(UND) != 0x12 || *c.spsrAddr(IRQ) != 0x6000003F {
+					t.Errorf("cpsr, spsr_und, spsr_irq = %08X, %08X, %08X, want 0000009B, 00000012, 6000003F", c.CPSR, *c.spsrAddr(UND), *c.spsrAddr(IRQ))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadSource(tt.source, false, tt.setup).CPU
+
+			for range tt.steps {
+				if err := c.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+// TestBranchRefill expects the refill after a branch to start with a
+// non-sequential fetch, even when the target follows on from the last one.
+func TestBranchRefill(t *testing.T) {
+	b := newTestBus(&conformanceVector{})
+	c := NewCPU(b, noIRQ{})
+	c.cpsrInitMode(SYS)
+	c.R[15] = 0x08000108
+	c.curr, c.next = 0x08000100, 0x08000104
+	c.pipeline = [2]uint32{0xEA000001, 0} // b 0x0800010C
+
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint32{
+		accessNonsequential | accessCode, // 08000108
+		accessNonsequential | accessCode, // 0800010C
+		accessSequential | accessCode,    // 08000110
+	}
+	if len(b.got) != len(want) {
+		t.Fatalf("got %d accesses, want %d", len(b.got), len(want))
+	}
+	for i, w := range want {
+		if b.got[i].Access != w {
+			t.Errorf("access %d = %s, want access %d", i, b.got[i], w)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package gba
+
+// ArmClass is the encoding class of an ARM instruction, which selects the
+// handler that executes it.
+type ArmClass uint8
+
+const (
+	ArmClassUndefined ArmClass = iota
+	ArmClassBranchX
+	ArmClassMultiply
+	ArmClassMultiplyLong
+	ArmClassSwap
+	ArmClassMemoryHalf
+	ArmClassPSR
+	ArmClassSWI
+	ArmClassMemoryBlock
+	ArmClassBranch
+	ArmClassMemory
+	ArmClassALU
+)
+
+// DecodeArm returns the encoding class of an ARM instruction. Only bits 27-20
+// and 7-4 are looked at, so should-be-one and should-be-zero fields (such as
+// those of BX and SWP) are not checked.
+func DecodeArm(instruction uint32) ArmClass {
+	switch {
+	case instruction&0b0000_1111_1111_0000_0000_0000_1111_0000 == 0b0000_0001_0010_0000_0000_0000_0001_0000:
+		return ArmClassBranchX
+	case instruction&0b0000_1111_1100_0000_0000_0000_1111_0000 == 0b0000_0000_0000_0000_0000_0000_1001_0000:
+		return ArmClassMultiply
+	case instruction&0b0000_1111_1000_0000_0000_0000_1111_0000 == 0b0000_0000_1000_0000_0000_0000_1001_0000:
+		return ArmClassMultiplyLong
+	case instruction&0b0000_1111_1011_0000_0000_0000_1111_0000 == 0b0000_0001_0000_0000_0000_0000_1001_0000:
+		return ArmClassSwap
+	case instruction&0b0000_1110_0000_0000_0000_0000_1001_0000 == 0b0000_0000_0000_0000_0000_0000_1001_0000:
+		return ArmClassMemoryHalf
+	case instruction&0b0000_1111_1111_0000_0000_0000_0000_0000 == 0b0000_0001_0010_0000_0000_0000_0000_0000 &&
+		instruction&0b0000_0000_0000_0000_0000_0000_1111_0000 != 0:
+		// BLX, BKPT, QADD and the like are ARMv5, not MSR
+		return ArmClassUndefined
+	case instruction&0b0000_1101_1001_0000_0000_0000_0000_0000 == 0b0000_0001_0000_0000_0000_0000_0000_0000:
+		return ArmClassPSR
+	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1111_0000_0000_0000_0000_0000_0000:
+		return ArmClassSWI
+	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1000_0000_0000_0000_0000_0000_0000:
+		return ArmClassMemoryBlock
+	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1010_0000_0000_0000_0000_0000_0000:
+		return ArmClassBranch
+	case instruction&0b0000_1110_0000_0000_0000_0000_0001_0000 == 0b0000_0110_0000_0000_0000_0000_0001_0000:
+		return ArmClassUndefined
+	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0100_0000_0000_0000_0000_0000_0000:
+		return ArmClassMemory
+	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1110_0000_0000_0000_0000_0000_0000,
+		instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1100_0000_0000_0000_0000_0000_0000,
+		instruction&0b0000_1111_1110_0000_0000_0000_0000_0000 == 0b0000_1100_0100_0000_0000_0000_0000_0000:
+		return ArmClassUndefined
+	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0000_0000_0000_0000_0000_0000_0000:
+		return ArmClassALU
+	default:
+		return ArmClassUndefined
+	}
+}
+
+// ThumbClass is the encoding class of a Thumb instruction, which selects the
+// handler that executes it.
+type ThumbClass uint8
+
+const (
+	ThumbClassUndefined ThumbClass = iota
+	ThumbClassSWI
+	ThumbClassALU
+	ThumbClassHiReg
+	ThumbClassAddSub
+	ThumbClassMemoryPCRel
+	ThumbClassMemoryReg
+	ThumbClassMemoryImm
+	ThumbClassMemorySign
+	ThumbClassMemoryHalf
+	ThumbClassMemorySPRel
+	ThumbClassMemoryPCSP
+	ThumbClassMemoryBlock
+	ThumbClassShift
+	ThumbClassImm
+	ThumbClassBranchCond
+	ThumbClassBranchUncond
+	ThumbClassBranchLink1
+	ThumbClassBranchLink2
+	ThumbClassPushPop
+	ThumbClassAddSP
+)
+
+// DecodeThumb returns the encoding class of a Thumb instruction. Only the top
+// 10 bits are looked at.
+func DecodeThumb(instruction uint16) ThumbClass {
+	switch {
+	case instruction&0b1111_1111_0000_0000 == 0b1101_1111_0000_0000:
+		return ThumbClassSWI
+	case instruction&0b1111_1111_0000_0000 == 0b1101_1110_0000_0000:
+		return ThumbClassUndefined
+	case instruction&0b1111_1100_0000_0000 == 0b0100_0000_0000_0000:
+		return ThumbClassALU
+	case instruction&0b1111_1100_0000_0000 == 0b0100_0100_0000_0000:
+		return ThumbClassHiReg
+	case instruction&0b1111_1000_0000_0000 == 0b0001_1000_0000_0000:
+		return ThumbClassAddSub
+	case instruction&0b1111_1000_0000_0000 == 0b0100_1000_0000_0000:
+		return ThumbClassMemoryPCRel
+	case instruction&0b1111_0010_0000_0000 == 0b0101_0000_0000_0000:
+		return ThumbClassMemoryReg
+	case instruction&0b1110_0000_0000_0000 == 0b0110_0000_0000_0000:
+		return ThumbClassMemoryImm
+	case instruction&0b1111_0010_0000_0000 == 0b0101_0010_0000_0000:
+		return ThumbClassMemorySign
+	case instruction&0b1111_0000_0000_0000 == 0b1000_0000_0000_0000:
+		return ThumbClassMemoryHalf
+	case instruction&0b1111_0000_0000_0000 == 0b1001_0000_0000_0000:
+		return ThumbClassMemorySPRel
+	case instruction&0b1111_0000_0000_0000 == 0b1010_0000_0000_0000:
+		return ThumbClassMemoryPCSP
+	case instruction&0b1111_0000_0000_0000 == 0b1100_0000_0000_0000:
+		return ThumbClassMemoryBlock
+	case instruction&0b1110_0000_0000_0000 == 0b0000_0000_0000_0000:
+		return ThumbClassShift
+	case instruction&0b1110_0000_0000_0000 == 0b0010_0000_0000_0000:
+		return ThumbClassImm
+	case instruction&0b1111_0000_0000_0000 == 0b1101_0000_0000_0000:
+		return ThumbClassBranchCond
+	case instruction&0b1111_1000_0000_0000 == 0b1110_0000_0000_0000:
+		return ThumbClassBranchUncond
+	case instruction&0b1111_1000_0000_0000 == 0b1111_0000_0000_0000:
+		return ThumbClassBranchLink1
+	case instruction&0b1110_1000_0000_0000 == 0b1110_1000_0000_0000:
+		return ThumbClassBranchLink2
+	case instruction&0b1111_0110_0000_0000 == 0b1011_0100_0000_0000:
+		return ThumbClassPushPop
+	case instruction&0b1111_1111_0000_0000 == 0b1011_0000_0000_0000:
+		return ThumbClassAddSP
+	default:
+		return ThumbClassUndefined
+	}
+}
+
+type handler func(c *CPU, instruction uint32)
+
+var armHandlers = [...]handler{
+	ArmClassUndefined:    (*CPU).undefined,
+	ArmClassBranchX:      (*CPU).ArmBranchX,
+	ArmClassMultiply:     (*CPU).ArmMultiply,
+	ArmClassMultiplyLong: (*CPU).ArmMultiplyLong,
+	ArmClassSwap:         (*CPU).ArmSwap,
+	ArmClassMemoryHalf:   (*CPU).Arm_MemoryHalf,
+	ArmClassPSR:          (*CPU).ArmPSR,
+	ArmClassSWI:          (*CPU).ArmSWI,
+	ArmClassMemoryBlock:  (*CPU).ArmMemoryBlock,
+	ArmClassBranch:       (*CPU).ArmBranch,
+	ArmClassMemory:       (*CPU).ArmMemory,
+	ArmClassALU:          (*CPU).ArmALU,
+}
+
+var thumbHandlers = [...]handler{
+	ThumbClassUndefined:    (*CPU).undefined,
+	ThumbClassSWI:          (*CPU).ThumbSWI,
+	ThumbClassALU:          (*CPU).ThumbALU,
+	ThumbClassHiReg:        (*CPU).ThumbHiReg,
+	ThumbClassAddSub:       (*CPU).ThumbAddSub,
+	ThumbClassMemoryPCRel:  (*CPU).ThumbMemoryPCRel,
+	ThumbClassMemoryReg:    (*CPU).ThumbMemoryReg,
+	ThumbClassMemoryImm:    (*CPU).ThumbMemoryImm,
+	ThumbClassMemorySign:   (*CPU).ThumbMemorySign,
+	ThumbClassMemoryHalf:   (*CPU).ThumbMemoryHalf,
+	ThumbClassMemorySPRel:  (*CPU).ThumbMemorySPRel,
+	ThumbClassMemoryPCSP:   (*CPU).ThumbMemoryPCSP,
+	ThumbClassMemoryBlock:  (*CPU).ThumbMemoryBlock,
+	ThumbClassShift:        (*CPU).ThumbShift,
+	ThumbClassImm:          (*CPU).ThumbImm,
+	ThumbClassBranchCond:   (*CPU).ThumbBranchCond,
+	ThumbClassBranchUncond: (*CPU).ThumbBranchUncond,
+	ThumbClassBranchLink1:  (*CPU).ThumbBranchLink1,
+	ThumbClassBranchLink2:  (*CPU).ThumbBranchLink2,
+	ThumbClassPushPop:      (*CPU).ThumbPushPop,
+	ThumbClassAddSP:        (*CPU).ThumbAddSP,
+}
+
+// armIndex selects the decode table entry for an ARM instruction from bits
+// 27-20 and 7-4.
+func armIndex(instruction uint32) uint32 {
+	return (instruction>>16)&0xFF0 | (instruction>>4)&0xF
+}
+
+// thumbIndex selects the decode table entry for a Thumb instruction from its
+// top 10 bits.
+func thumbIndex(instruction uint32) uint32 {
+	return (instruction >> 6) & 0x3FF
+}
+
+var (
+	armClasses   [4096]ArmClass
+	armTable     [4096]handler
+	thumbClasses [1024]ThumbClass
+	thumbTable   [1024]handler
+)
+
+func init() {
+	for i := range armTable {
+		instruction := uint32(i)&0xFF0<<16 | uint32(i)&0xF<<4
+		class := DecodeArm(instruction)
+		armClasses[i] = class
+		armTable[i] = armHandlers[class]
+
+		if class == ArmClassALU {
+			op := &aluOps[ReadBits(instruction, 21, 4)]
+			armTable[i] = func(c *CPU, instruction uint32) {
+				c.armALU(instruction, op)
+			}
+		}
+	}
+
+	for i := range thumbTable {
+		class := DecodeThumb(uint16(i << 6))
+		thumbClasses[i] = class
+		thumbTable[i] = thumbHandlers[class]
+	}
+}
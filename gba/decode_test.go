@@ -0,0 +1,231 @@
+package gba
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// baselineDecodeArm is the mask chain Arm walked before the decode tables,
+// kept as it was so the tables can be checked against it.
+func baselineDecodeArm(instruction uint32) ArmClass {
+	switch {
+	case instruction&0b0000_1111_1111_1111_1111_1111_0000_0000 == 0b0000_0001_0010_1111_1111_1111_0000_0000:
+		return ArmClassBranchX
+	case instruction&0b0000_1111_1100_0000_0000_0000_1111_0000 == 0b0000_0000_0000_0000_0000_0000_1001_0000:
+		return ArmClassMultiply
+	case instruction&0b0000_1111_1000_0000_0000_0000_1111_0000 == 0b0000_0000_1000_0000_0000_0000_1001_0000:
+		return ArmClassMultiplyLong
+	case instruction&0b0000_1111_1011_0000_0000_1111_1111_0000 == 0b0000_0001_0000_0000_0000_0000_1001_0000:
+		return ArmClassSwap
+	case instruction&0b0000_1110_0000_0000_0000_0000_1001_0000 == 0b0000_0000_0000_0000_0000_0000_1001_0000:
+		return ArmClassMemoryHalf
+	case instruction&0b0000_1101_1001_0000_0000_0000_0000_0000 == 0b0000_0001_0000_0000_0000_0000_0000_0000:
+		return ArmClassPSR
+	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1111_0000_0000_0000_0000_0000_0000:
+		return ArmClassSWI
+	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1000_0000_0000_0000_0000_0000_0000:
+		return ArmClassMemoryBlock
+	case instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1010_0000_0000_0000_0000_0000_0000:
+		return ArmClassBranch
+	case instruction&0b0000_1110_0000_0000_0000_0000_0001_0000 == 0b0000_0110_0000_0000_0000_0000_0001_0000:
+		return ArmClassUndefined
+	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0100_0000_0000_0000_0000_0000_0000:
+		return ArmClassMemory
+	case instruction&0b0000_1111_0000_0000_0000_0000_0000_0000 == 0b0000_1110_0000_0000_0000_0000_0000_0000,
+		instruction&0b0000_1110_0000_0000_0000_0000_0000_0000 == 0b0000_1100_0000_0000_0000_0000_0000_0000,
+		instruction&0b0000_1111_1110_0000_0000_0000_0000_0000 == 0b0000_1100_0100_0000_0000_0000_0000_0000:
+		return ArmClassUndefined
+	case instruction&0b0000_1100_0000_0000_0000_0000_0000_0000 == 0b0000_0000_0000_0000_0000_0000_0000_0000:
+		return ArmClassALU
+	default:
+		return ArmClassUndefined
+	}
+}
+
+// baselineDecodeThumb is the mask chain Thumb walked before the decode
+// tables, kept as it was so the tables can be checked against it.
+func baselineDecodeThumb(instruction uint16) ThumbClass {
+	switch {
+	case instruction&0b1111_1111_0000_0000 == 0b1101_1111_0000_0000:
+		return ThumbClassSWI
+	case instruction&0b1111_1111_0000_0000 == 0b1101_1110_0000_0000:
+		return ThumbClassUndefined
+	case instruction&0b1111_1100_0000_0000 == 0b0100_0000_0000_0000:
+		return ThumbClassALU
+	case instruction&0b1111_1100_0000_0000 == 0b0100_0100_0000_0000:
+		return ThumbClassHiReg
+	case instruction&0b1111_1000_0000_0000 == 0b0001_1000_0000_0000:
+		return ThumbClassAddSub
+	case instruction&0b1111_1000_0000_0000 == 0b0100_1000_0000_0000:
+		return ThumbClassMemoryPCRel
+	case instruction&0b1111_0010_0000_0000 == 0b0101_0000_0000_0000:
+		return ThumbClassMemoryReg
+	case instruction&0b1110_0000_0000_0000 == 0b0110_0000_0000_0000:
+		return ThumbClassMemoryImm
+	case instruction&0b1111_0010_0000_0000 == 0b0101_0010_0000_0000:
+		return ThumbClassMemorySign
+	case instruction&0b1111_0000_0000_0000 == 0b1000_0000_0000_0000:
+		return ThumbClassMemoryHalf
+	case instruction&0b1111_0000_0000_0000 == 0b1001_0000_0000_0000:
+		return ThumbClassMemorySPRel
+	case instruction&0b1111_0000_0000_0000 == 0b1010_0000_0000_0000:
+		return ThumbClassMemoryPCSP
+	case instruction&0b1111_0000_0000_0000 == 0b1100_0000_0000_0000:
+		return ThumbClassMemoryBlock
+	case instruction&0b1110_0000_0000_0000 == 0b0000_0000_0000_0000:
+		return ThumbClassShift
+	case instruction&0b1110_0000_0000_0000 == 0b0010_0000_0000_0000:
+		return ThumbClassImm
+	case instruction&0b1111_0000_0000_0000 == 0b1101_0000_0000_0000:
+		return ThumbClassBranchCond
+	case instruction&0b1111_1000_0000_0000 == 0b1110_0000_0000_0000:
+		return ThumbClassBranchUncond
+	case instruction&0b1111_1000_0000_0000 == 0b1111_0000_0000_0000:
+		return ThumbClassBranchLink1
+	case instruction&0b1110_1000_0000_0000 == 0b1110_1000_0000_0000:
+		return ThumbClassBranchLink2
+	case instruction&0b1111_0110_0000_0000 == 0b1011_0100_0000_0000:
+		return ThumbClassPushPop
+	case instruction&0b1111_1111_0000_0000 == 0b1011_0000_0000_0000:
+		return ThumbClassAddSP
+	default:
+		return ThumbClassUndefined
+	}
+}
+
+func TestArmTable(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for i := range armTable {
+		key := uint32(i)&0xFF0<<16 | uint32(i)&0xF<<4
+
+		for range 64 {
+			instruction := key | r.Uint32()&^0x0FF000F0
+			if instruction&0x0FB000F0 == 0x01000090 {
+				// SWP's should-be-zero bits aren't decoded either
+				instruction &^= 0x00000F00
+			}
+			want := baselineDecodeArm(instruction)
+			if ReadBits(instruction, 20, 8) == 0x12 {
+				// The tables don't see BX's should-be-one bits, and send the
+				// ARMv5 encodings beside it to undefined rather than MSR
+				switch op := ReadBits(instruction, 4, 4); {
+				case op == 0b0000:
+					want = ArmClassPSR
+				case op == 0b0001:
+					want = ArmClassBranchX
+				case op&0b1001 == 0b1001:
+					want = ArmClassMemoryHalf
+				default:
+					want = ArmClassUndefined
+				}
+			}
+
+			if got := armClasses[i]; got != want {
+				t.Fatalf("%08X: table entry %03X has class %d, baseline decoder gives %d", instruction, i, got, want)
+			}
+			if got := DecodeArm(instruction); got != want {
+				t.Fatalf("%08X: decoder gives class %d, baseline decoder gives %d", instruction, got, want)
+			}
+		}
+	}
+}
+
+func TestArmTableARMv5(t *testing.T) {
+	for _, instruction := range []uint32{
+		0xE12FFF30, // BLX r0
+		0xE1200070, // BKPT #0
+		0xE1210052, // QSUB r0, r2, r1
+		0xE1200380, // SMLAWB r0, r0, r3, r0
+	} {
+		if got := armClasses[armIndex(instruction)]; got != ArmClassUndefined {
+			t.Errorf("%08X: got class %d, want undefined", instruction, got)
+		}
+	}
+}
+
+func TestThumbTable(t *testing.T) {
+	for instruction := range 1 << 16 {
+		i := thumbIndex(uint32(instruction))
+		want := baselineDecodeThumb(uint16(instruction))
+		if got := thumbClasses[i]; got != want {
+			t.Fatalf("%04X: table entry %03X has class %d, baseline decoder gives %d", instruction, i, got, want)
+		}
+		if got := DecodeThumb(uint16(instruction)); got != want {
+			t.Fatalf("%04X: decoder gives class %d, baseline decoder gives %d", instruction, got, want)
+		}
+	}
+}
+
+var armMix = []uint32{
+	0xE3A00001, // MOV r0, #1
+	0xE0901001, // ADDS r1, r0, r1
+	0xE0412100, // SUB r2, r1, r0, LSL #2
+	0xE20130FF, // AND r3, r1, #0xFF
+	0xE1510002, // CMP r1, r2
+	0xE0040291, // MUL r4, r1, r2
+	0xE5975000, // LDR r5, [r7]
+	0xE5875004, // STR r5, [r7, #4]
+	0xE1D760B2, // LDRH r6, [r7, #2]
+	0xE1800271, // ORR r0, r0, r1, ROR r2
+}
+
+var thumbMix = []uint32{
+	0x2001, // MOV r0, #1
+	0x1841, // ADD r1, r0, r1
+	0x008A, // LSL r2, r1, #2
+	0x400B, // AND r3, r1
+	0x4291, // CMP r1, r2
+	0x4354, // MUL r4, r2
+	0x683D, // LDR r5, [r7]
+	0x607D, // STR r5, [r7, #4]
+	0x887E, // LDRH r6, [r7, #2]
+	0x3003, // ADD r0, #3
+}
+
+func benchCPU() *CPU {
+	c := NewMotherboard(nil).CPU
+	c.cpsrInitMode(SYS)
+	c.R[7] = WRAM2.Start
+	return c
+}
+
+func benchDispatch(b *testing.B, mix []uint32, exec func(c *CPU, instruction uint32)) {
+	c := benchCPU()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, instruction := range mix {
+			exec(c, instruction)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(mix))/b.Elapsed().Seconds(), "instr/s")
+}
+
+// BenchmarkArmDispatch compares decoding through the DecodeArm switch on every
+// instruction, as Arm used to, with the precomputed table.
+func BenchmarkArmDispatch(b *testing.B) {
+	b.Run("switch", func(b *testing.B) {
+		benchDispatch(b, armMix, func(c *CPU, instruction uint32) {
+			if c.cond(ReadBits(instruction, 28, 4)) {
+				armHandlers[DecodeArm(instruction)](c, instruction)
+			}
+		})
+	})
+	b.Run("table", func(b *testing.B) {
+		benchDispatch(b, armMix, (*CPU).Arm)
+	})
+}
+
+// BenchmarkThumbDispatch compares decoding through the DecodeThumb switch on
+// every instruction, as Thumb used to, with the precomputed table.
+func BenchmarkThumbDispatch(b *testing.B) {
+	b.Run("switch", func(b *testing.B) {
+		benchDispatch(b, thumbMix, func(c *CPU, instruction uint32) {
+			thumbHandlers[DecodeThumb(uint16(instruction))](c, instruction)
+		})
+	})
+	b.Run("table", func(b *testing.B) {
+		benchDispatch(b, thumbMix, (*CPU).Thumb)
+	})
+}
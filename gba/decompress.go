@@ -0,0 +1,264 @@
+package gba
+
+// The decompression calls follow the BIOS routines access for access, so
+// their output matches it even where the BIOS reads back bytes it has not
+// finished writing. Each charges the internal cycles the BIOS loops spend
+// alongside the timed loads and stores.
+
+// biosSourceValid reports whether the BIOS accepts source as the input of a
+// call producing size bytes. Once the BIOS has booted it refuses to
+// decompress data in or ending in the BIOS itself.
+func (c *CPU) biosSourceValid(source, size uint32) bool {
+	if size == 0 {
+		return false
+	}
+	if c.read8(uint32(POSTFLG)) != 1 {
+		return true
+	}
+	end := source + size&^0xFE000000
+	return source&0x0E000000 != 0 && end&0x0E000000 != 0
+}
+
+// lz77UnCompWram decompresses LZ77 data from R0 to R1 a byte at a time.
+// Copied blocks are written in full, so the output may overrun the size in
+// the header by up to 17 bytes.
+func (c *CPU) lz77UnCompWram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.readWord(source) >> 8)
+	source += 4
+	c.idle(120)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	for size > 0 {
+		flags := c.read8(source)
+		source++
+		c.idle(9)
+
+		for range 8 {
+			if flags&0x80 == 0 {
+				c.write8(destination, c.read8(source))
+				source++
+				destination++
+				size--
+				c.idle(16)
+			} else {
+				length, disp := c.lz77Block(source)
+				source += 2
+				size -= int32(length)
+				for range length {
+					c.write8(destination, c.read8(destination-disp))
+					destination++
+					c.idle(7)
+				}
+				c.idle(19)
+			}
+			if size <= 0 {
+				break
+			}
+			flags <<= 1
+		}
+	}
+}
+
+// lz77UnCompVram decompresses LZ77 data from R0 to R1 for VRAM, which
+// ignores byte writes. Output bytes are paired into halfwords, and copied
+// bytes are picked out of halfwords read back from the destination, so a
+// block copying the byte just before it repeats the last halfword written
+// rather than the pending byte.
+func (c *CPU) lz77UnCompVram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.readWord(source) >> 8)
+	source += 4
+	c.idle(125)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	var pending, shift uint32
+	put := func(b uint32) {
+		pending |= b << shift
+		shift ^= 8
+		if shift == 0 {
+			c.write16(destination, uint16(pending))
+			destination += 2
+			pending = 0
+		}
+	}
+
+	for size > 0 {
+		flags := c.read8(source)
+		source++
+		c.idle(9)
+
+		for range 8 {
+			if flags&0x80 == 0 {
+				put(uint32(c.read8(source)))
+				source++
+				size--
+				c.idle(16)
+			} else {
+				length, disp := c.lz77Block(source)
+				source += 2
+				size -= int32(length)
+				lane := (8 - shift) ^ (disp&1)<<3
+				for range length {
+					lane ^= 8
+					offset := (disp + (8-shift)>>3) &^ 1
+					half := c.readHalf(destination - offset)
+					put(half & (0xFF << lane) >> lane)
+					c.idle(22)
+				}
+				c.idle(24)
+			}
+			if size <= 0 {
+				break
+			}
+			flags <<= 1
+		}
+	}
+}
+
+// lz77Block reads the length and displacement of a copied block.
+func (c *CPU) lz77Block(source uint32) (length, disp uint32) {
+	b0 := uint32(c.read8(source))
+	b1 := uint32(c.read8(source + 1))
+	return 3 + b0>>4, (b0&0xF)<<8 | b1 + 1
+}
+
+// huffUnComp decodes Huffman data from R0 to R1. Decoded units are packed
+// into words, and the last word is only written once it is full.
+func (c *CPU) huffUnComp() {
+	source, destination := c.R[0], c.R[1]
+	c.idle(130)
+	if !c.biosSourceValid(source, 0x02000000) {
+		return
+	}
+
+	bits := uint32(c.read8(source)) & 0xF
+	perWord := bits&7 + 4
+	size := int32(c.readWord(source) >> 8)
+	root := source + 5
+	stream := source + 4 + (uint32(c.read8(source+4))+1)*2
+
+	node := root
+	var pending, units uint32
+	for size > 0 {
+		word := c.readWord(stream)
+		stream += 4
+		c.idle(6)
+
+		for range 32 {
+			bit := word >> 31
+			flags := uint32(c.read8(node))
+			child := node&^1 + (flags&0x3F+1)*2 + bit
+			if flags<<bit&0x80 == 0 {
+				node = child
+				c.idle(30)
+			} else {
+				pending = pending>>bits | uint32(c.read8(child))<<(32-bits)
+				node = root
+				units++
+				if units == perWord {
+					c.write32(destination, pending)
+					destination += 4
+					size -= 4
+					units = 0
+				}
+				c.idle(44)
+			}
+			if size <= 0 {
+				break
+			}
+			word <<= 1
+		}
+	}
+}
+
+// rlUnCompWram decompresses run-length encoded data from R0 to R1 a byte at
+// a time. The last run is written in full, whatever the size in the header.
+func (c *CPU) rlUnCompWram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.read32(source) >> 8)
+	source += 4
+	c.idle(120)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	for size > 0 {
+		flag := c.read8(source)
+		source++
+		c.idle(14)
+
+		if flag&0x80 == 0 {
+			n := int32(flag&0x7F) + 1
+			size -= n
+			for range n {
+				c.write8(destination, c.read8(source))
+				source++
+				destination++
+				c.idle(9)
+			}
+		} else {
+			n := int32(flag&0x7F) + 3
+			size -= n
+			value := c.read8(source)
+			source++
+			for range n {
+				c.write8(destination, value)
+				destination++
+				c.idle(6)
+			}
+		}
+	}
+}
+
+// rlUnCompVram decompresses run-length encoded data from R0 to R1 for VRAM,
+// pairing output bytes into halfwords. A trailing odd byte is never written.
+func (c *CPU) rlUnCompVram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.read32(source) >> 8)
+	source += 4
+	c.idle(125)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	var pending, shift uint32
+	put := func(b uint8) {
+		pending |= uint32(b) << shift
+		shift ^= 8
+		if shift == 0 {
+			c.write16(destination, uint16(pending))
+			destination += 2
+			pending = 0
+		}
+	}
+
+	for size > 0 {
+		flag := c.read8(source)
+		source++
+		c.idle(16)
+
+		if flag&0x80 == 0 {
+			n := int32(flag&0x7F) + 1
+			size -= n
+			for range n {
+				put(c.read8(source))
+				source++
+				c.idle(17)
+			}
+		} else {
+			n := int32(flag&0x7F) + 3
+			size -= n
+			value := c.read8(source)
+			source++
+			for range n {
+				put(value)
+				c.idle(15)
+			}
+		}
+	}
+}
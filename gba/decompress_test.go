@@ -0,0 +1,70 @@
+package gba
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// TestDecompress compares the decompression calls with the BIOS into WRAM
+// and VRAM, with odd sizes and destinations, and from a source the BIOS
+// refuses once it has booted.
+func TestDecompress(t *testing.T) {
+	var (
+		source = WRAM1.Start
+		wram   = WRAM1.Start + 0x10000
+		vram   = VRAM.Start + 0x100
+	)
+
+	// random fills the compressed stream with random bytes after its header,
+	// which the BIOS decodes as happily as real data.
+	random := func(seed uint64) []byte {
+		r := rand.New(rand.NewPCG(seed, 0))
+		data := make([]byte, 0x1000)
+		for i := range data {
+			data[i] = byte(r.Uint32())
+		}
+		return data
+	}
+	header := func(kind byte, size uint32, data []byte) []byte {
+		return append([]byte{kind, byte(size), byte(size >> 8), byte(size >> 16)}, data...)
+	}
+
+	tests := []struct {
+		name        string
+		swi         uint32
+		source      uint32
+		destination uint32
+		input       []byte
+		postflg     uint8
+	}{
+		{"lz77 wram", LZ77UnCompWram, source, wram, header(0x10, 0x200, random(1)), 0},
+		{"lz77 wram odd", LZ77UnCompWram, source, wram + 1, header(0x10, 0x1FF, random(2)), 0},
+		{"lz77 vram", LZ77UnCompVram, source, vram, header(0x10, 0x200, random(3)), 0},
+		{"lz77 vram odd size", LZ77UnCompVram, source, vram, header(0x10, 0x101, random(4)), 0},
+		{"lz77 vram repeat", LZ77UnCompVram, source, vram, header(0x10, 0x40, []byte{0x40, 'a', 'b', 0xF0, 0x00, 0xF0, 0x01, 0xF0, 0x02}), 0},
+		{"lz77 wram repeat", LZ77UnCompWram, source, wram, header(0x10, 0x40, []byte{0x40, 'a', 'b', 0xF0, 0x00, 0xF0, 0x01, 0xF0, 0x02}), 0},
+		{"huffman 8 bit", HuffUnComp, source, wram, header(0x28, 0x100, random(5)), 0},
+		{"huffman 4 bit", HuffUnComp, source, wram, header(0x24, 0x100, random(6)), 0},
+		{"rle wram", RLUnCompWram, source, wram, header(0x30, 0x200, random(7)), 0},
+		{"rle wram odd", RLUnCompWram, source, wram + 3, header(0x30, 0x3F, random(8)), 0},
+		{"rle vram", RLUnCompVram, source, vram, header(0x30, 0x200, random(9)), 0},
+		{"rle vram odd size", RLUnCompVram, source, vram, header(0x30, 0x81, random(10)), 0},
+		{"empty", LZ77UnCompWram, source, wram, header(0x10, 0, random(11)), 0},
+		{"booted", RLUnCompWram, source, wram, header(0x30, 0x200, random(12)), 1},
+		{"bios source", RLUnCompWram, BIOS.Start + 0x3C00, wram, nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := func(m *Motherboard) {
+				for i, b := range tt.input {
+					m.Memory.Set8(tt.source+uint32(i), b, false, false)
+				}
+				SetIORegister(m.Memory, POSTFLG, tt.postflg)
+				m.CPU.R[0] = tt.source
+				m.CPU.R[1] = tt.destination
+			}
+			compareSWI(t, tt.swi, tt.destination, setup)
+		})
+	}
+}
@@ -68,7 +68,7 @@ func (d *DMAController) transfer(timing uint16) {
 		}
 
 		if irq == 1 {
-			panic("oops")
+			d.Interrupts.Raise(IRQDMA0 + Interrupt(i))
 		}
 
 		SetIORegister(d.Memory, CNT_Hs[i], SetBits(cnth, 15, 1, repeat)) // store repeat bit in enable flag
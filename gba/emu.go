@@ -2,6 +2,7 @@ package gba
 
 import (
 	"image"
+	"runtime/debug"
 	"time"
 )
 
@@ -16,32 +17,47 @@ func NewEmu(gamepak []byte) *Emulator {
 }
 
 func (e *Emulator) PreBoot() {
-	SetIORegister(e.CPU.Memory, DISPCNT, 0x80)
+	SetIORegister(e.Memory, DISPCNT, 0x80)
+	SetIORegister(e.Memory, KEYINPUT, 0x03FF)
 	e.CPU.exception(0x08)
 }
 
-func (e *Emulator) Boot() {
+func (e *Emulator) Boot() error {
 	e.PreBoot()
-	e.Run()
+	return e.Run()
 }
 
-func (e *Emulator) Run() {
+// Run emulates frames in real time until the CPU faults. A panic from the
+// rest of the system is recovered and returned as a fault too.
+func (e *Emulator) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.CPU.fail(&PanicError{Value: r, Stack: debug.Stack()})
+			err = e.CPU.Err()
+		}
+	}()
+
 	ticker := time.NewTicker(16739000 * time.Nanosecond)
 	for {
 		<-ticker.C
-		e.frame()
+		if err := e.frame(); err != nil {
+			return err
+		}
 	}
 }
 
-func (e *Emulator) frame() {
+func (e *Emulator) frame() error {
 	for line := uint16(0); line < 228; line++ {
-		e.scanline(line)
+		if err := e.scanline(line); err != nil {
+			return err
+		}
 	}
 
 	e.LCD.DrawFrame()
+	return nil
 }
 
-func (e *Emulator) scanline(line uint16) {
+func (e *Emulator) scanline(line uint16) error {
 	SetIORegister(e.Memory, VCOUNT, line)
 
 	dispstat := ReadIORegister(e.Memory, DISPSTAT)
@@ -55,26 +71,68 @@ func (e *Emulator) scanline(line uint16) {
 
 	SetIORegister(e.Memory, DISPSTAT, dispstat)
 
+	e.Interrupts.checkKeypad()
+
+	if line == 160 && ReadBits(dispstat, 3, 1) == 1 {
+		e.Interrupts.Raise(IRQVBlank)
+	}
+	if VCounter == 1 && ReadBits(dispstat, 5, 1) == 1 {
+		e.Interrupts.Raise(IRQVCounter)
+	}
+
 	blank := ReadBits(ReadIORegister(e.Memory, DISPCNT), 7, 1)
 
 	for e.CPU.cycles = e.CPU.cycles % 1232; e.CPU.cycles < 1232; {
-		e.step()
+		if err := e.step(); err != nil {
+			return err
+		}
 	}
 
 	if line < 160 {
 		e.LCD.DrawLine(line, blank)
 	}
+	return nil
 }
 
-func (e *Emulator) step() {
+func (e *Emulator) step() error {
 	dispstat := ReadIORegister(e.Memory, DISPSTAT)
 	HBlank := (1005 - e.CPU.cycles) >> 31 // 0: 0-1005, 1: 1006-1231
+	if ReadBits(dispstat, 1, 1) == 0 && HBlank == 1 && ReadBits(dispstat, 4, 1) == 1 {
+		e.Interrupts.Raise(IRQHBlank)
+	}
 	dispstat = SetBits(dispstat, 1, 1, uint16(HBlank))
 	SetIORegister(e.Memory, DISPSTAT, dispstat)
 
 	preCount := e.CPU.cycles
-	e.stepCPU()
+	var err error
+	if e.CPU.halted {
+		e.idle()
+	} else {
+		err = e.stepCPU()
+	}
 	postCount := e.CPU.cycles
 
-	e.Timer.Tick(postCount - preCount)
+	if !e.CPU.stopped {
+		e.Timer.Tick(postCount - preCount)
+	}
+	return err
+}
+
+// idle runs the system while the CPU is halted, skipping ahead to the next
+// thing that could wake it: the HBlank or scanline edge, or a timer
+// overflowing. The timers catch up in a single tick.
+func (e *Emulator) idle() {
+	if e.Interrupts.Requested() {
+		e.CPU.wake()
+		return
+	}
+
+	next := uint32(1006)
+	if e.CPU.cycles >= next {
+		next = 1232
+	}
+	if overflow, ok := e.Timer.nextOverflow(); ok && !e.CPU.stopped {
+		next = min(next, e.CPU.cycles+overflow)
+	}
+	e.CPU.cycle(next - e.CPU.cycles)
 }
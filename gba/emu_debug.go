@@ -19,8 +19,16 @@ const (
 	PostStepCPUEmuHook
 )
 
-func (e *Emulator) stepCPU() {
+// stepCPU runs the hooks around each step, which is a whole translated block
+// when the CPU recompiles.
+func (e *Emulator) stepCPU() error {
 	e.Hooks.Hook(PreStepCPUEmuHook, e)
-	e.CPU.Step()
+	var err error
+	if e.CPU.Recompile {
+		err = e.CPU.StepBlock()
+	} else {
+		err = e.CPU.Step()
+	}
 	e.Hooks.Hook(PostStepCPUEmuHook, e)
+	return err
 }
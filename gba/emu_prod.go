@@ -6,6 +6,9 @@ type Emulator struct {
 	*Motherboard
 }
 
-func (e *Emulator) stepCPU() {
-	e.CPU.Step()
+func (e *Emulator) stepCPU() error {
+	if e.CPU.Recompile {
+		return e.CPU.StepBlock()
+	}
+	return e.CPU.Step()
 }
@@ -0,0 +1,156 @@
+package gba
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestHALTCNT expects a write to HALTCNT to halt the CPU, stopping it as
+// well when bit 7 is set, and a halted CPU to run no instructions.
+func TestHALTCNT(t *testing.T) {
+	for _, stop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stop %t", stop), func(t *testing.T) {
+			value := 0
+			if stop {
+				value = 0x80
+			}
+			m := loadSource(fmt.Sprintf(`
+	mov	r0, #0x04000000
+	add	r0, r0, #0x300
+	mov	r1, #%d
+	strb	r1, [r0, #1]
+	mov	r2, #1
+`, value), false, nil)
+			e := &Emulator{Motherboard: m}
+
+			for range 4 {
+				if err := e.step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !m.CPU.halted || m.CPU.stopped != stop {
+				t.Fatalf("halted = %t, stopped = %t, want true, %t", m.CPU.halted, m.CPU.stopped, stop)
+			}
+
+			if err := e.step(); err != nil {
+				t.Fatal(err)
+			}
+			if m.CPU.R[2] != 0 {
+				t.Errorf("halted CPU ran the next instruction")
+			}
+		})
+	}
+}
+
+// TestHaltWake halts the CPU and runs the system, expecting it to wake as
+// soon as an interrupt enabled in IE is requested, whatever IME says.
+func TestHaltWake(t *testing.T) {
+	timer := func(m *Motherboard) {
+		m.Memory.Set32(uint32(TM0CNT_L), 0x00C0FF00, false, false) // IRQ in 256 cycles
+	}
+
+	tests := []struct {
+		name  string
+		stop  bool
+		setup func(m *Motherboard)
+		wake  bool
+		at    uint32 // cycle woken at, if not zero
+	}{
+		{
+			name: "timer",
+			setup: func(m *Motherboard) {
+				timer(m)
+				SetIORegister(m.Memory, IE, 1<<IRQTimer0)
+				SetIORegister(m.Memory, IME, 1)
+			},
+			wake: true,
+			at:   256,
+		},
+		{
+			name: "timer with IME off",
+			setup: func(m *Motherboard) {
+				timer(m)
+				SetIORegister(m.Memory, IE, 1<<IRQTimer0)
+			},
+			wake: true,
+			at:   256,
+		},
+		{
+			name: "timer not in IE",
+			setup: func(m *Motherboard) {
+				timer(m)
+				SetIORegister(m.Memory, IE, 1<<IRQVBlank)
+				SetIORegister(m.Memory, IME, 1)
+			},
+		},
+		{
+			name: "timer while stopped",
+			stop: true,
+			setup: func(m *Motherboard) {
+				timer(m)
+				SetIORegister(m.Memory, IE, 1<<IRQTimer0)
+			},
+		},
+		{
+			name: "keypad while stopped",
+			stop: true,
+			setup: func(m *Motherboard) {
+				SetIORegister(m.Memory, KEYCNT, 0x4001)   // IRQ on A
+				SetIORegister(m.Memory, KEYINPUT, 0x03FE) // A held
+				SetIORegister(m.Memory, IE, 1<<IRQKeypad)
+			},
+			wake: true,
+		},
+		{
+			name: "keypad not held",
+			stop: true,
+			setup: func(m *Motherboard) {
+				SetIORegister(m.Memory, KEYCNT, 0x4001)
+				SetIORegister(m.Memory, KEYINPUT, 0x03FF)
+				SetIORegister(m.Memory, IE, 1<<IRQKeypad)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := loadSource("done:\tb done", false, nil)
+			e := &Emulator{Motherboard: m}
+			tt.setup(m)
+			m.CPU.halt(tt.stop)
+
+			m.CPU.cycles = 0
+			e.Interrupts.checkKeypad() // as each scanline starts
+			for m.CPU.halted && m.CPU.cycles < 1232 {
+				if err := e.step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if woke := !m.CPU.halted; woke != tt.wake {
+				t.Fatalf("woke = %t, want %t", woke, tt.wake)
+			}
+			if tt.at != 0 && m.CPU.cycles != tt.at {
+				t.Errorf("woke at cycle %d, want %d", m.CPU.cycles, tt.at)
+			}
+			if got := ReadIORegister(m.Memory, TM0CNT_L); tt.stop && got&0xFF != 0 {
+				t.Errorf("TM0CNT_L = %04X, counted while stopped", got)
+			}
+		})
+	}
+}
+
+// TestStepCPURecompile expects a recompiling CPU to run a whole block each
+// step, in debug builds too.
+func TestStepCPURecompile(t *testing.T) {
+	m := loadSource("mov r0, #1\nmov r1, #2\nmov r2, #3\ndone:\tb done", false, nil)
+	m.CPU.Recompile = true
+	e := &Emulator{Motherboard: m}
+
+	if err := e.stepCPU(); err != nil {
+		t.Fatal(err)
+	}
+	if m.CPU.R[0] != 1 || m.CPU.R[1] != 2 || m.CPU.R[2] != 3 {
+		t.Errorf("r0-r2 = %d, %d, %d after one step, want the block run", m.CPU.R[0], m.CPU.R[1], m.CPU.R[2])
+	}
+}
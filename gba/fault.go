@@ -0,0 +1,151 @@
+package gba
+
+import (
+	"fmt"
+)
+
+// traceLength is how many of the last executed instructions a fault reports.
+const traceLength = 32
+
+// Traced is an instruction the CPU executed.
+type Traced struct {
+	PC     uint32
+	Opcode uint32
+	Thumb  bool
+}
+
+func (c *CPU) record() {
+	c.trace[c.traced%traceLength] = Traced{
+		PC:     c.curr,
+		Opcode: c.pipeline[0],
+		Thumb:  c.cpsrState() == 1,
+	}
+	c.traced++
+}
+
+// traceback returns the recorded instructions, oldest first.
+func (c *CPU) traceback() []Traced {
+	n := min(c.traced, traceLength)
+	trace := make([]Traced, 0, n)
+	for i := c.traced - n; i != c.traced; i++ {
+		trace = append(trace, c.trace[i%traceLength])
+	}
+	return trace
+}
+
+// fail stops the CPU with err, keeping the first error if it has already
+// stopped.
+func (c *CPU) fail(err error) {
+	if c.fault != nil {
+		return
+	}
+
+	c.fault = &Fault{
+		Err:          err,
+		PC:           c.curr,
+		Opcode:       c.pipeline[0],
+		CPURegisters: c.CPURegisters,
+		Trace:        c.traceback(),
+	}
+}
+
+// Err returns the fault that stopped the CPU, if any.
+func (c *CPU) Err() error {
+	if c.fault == nil {
+		return nil
+	}
+	return c.fault
+}
+
+// Fault is the error the CPU stops with. It wraps the cause with the state
+// of the CPU when it happened.
+type Fault struct {
+	Err    error
+	PC     uint32
+	Opcode uint32
+	CPURegisters
+
+	// Trace holds the last instructions executed, oldest first. The last
+	// is the one at PC.
+	Trace []Traced
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%v at %08X in %s mode", f.Err, f.PC, modeName(f.Mode()))
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// Mode returns the processor mode the CPU was in.
+func (f *Fault) Mode() uint32 {
+	return ReadBits(f.CPSR, 0, 5)
+}
+
+// Thumb reports whether the CPU was in Thumb state.
+func (f *Fault) Thumb() bool {
+	return ReadBits(f.CPSR, 5, 1) == 1
+}
+
+var modeNames = map[uint32]string{
+	USR: "USR",
+	FIQ: "FIQ",
+	IRQ: "IRQ",
+	SVC: "SVC",
+	ABT: "ABT",
+	UND: "UND",
+	SYS: "SYS",
+}
+
+func modeName(mode uint32) string {
+	if name, ok := modeNames[mode|0b10000]; ok {
+		return name
+	}
+	return fmt.Sprintf("%05b", mode)
+}
+
+// UndefinedError describes an instruction or SWI comment the CPU could not
+// execute in strict mode.
+type UndefinedError struct {
+	Opcode uint32
+	Thumb  bool
+	SWI    bool
+}
+
+func (e *UndefinedError) Error() string {
+	switch {
+	case e.SWI:
+		return fmt.Sprintf("undefined SWI 0x%02X", e.Opcode)
+	case e.Thumb:
+		return fmt.Sprintf("undefined thumb instruction %04X", e.Opcode)
+	default:
+		return fmt.Sprintf("undefined arm instruction %08X", e.Opcode)
+	}
+}
+
+// BusError describes an access the memory map could not serve.
+type BusError struct {
+	Address uint32
+	Size    uint32
+	Write   bool
+	Reason  string
+}
+
+func (e *BusError) Error() string {
+	kind := "read"
+	if e.Write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%d-bit %s of %08X: %s", e.Size*8, kind, e.Address, e.Reason)
+}
+
+// PanicError is a panic recovered from the emulation loop.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
@@ -0,0 +1,65 @@
+package gba
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFault(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint32
+		want    error
+	}{
+		{
+			name: "undefined",
+			program: []uint32{
+				0xE3A00001, // MOV r0, #1
+				0xE7F000F0, // UDF
+			},
+			want: &UndefinedError{Opcode: 0xE7F000F0},
+		},
+		{
+			name: "unmapped",
+			program: []uint32{
+				0xE3A01901, // MOV r1, #0x4000
+				0xE5910000, // LDR r0, [r1]
+			},
+			want: &BusError{Address: 0x4000, Size: 4, Reason: "unmapped address"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadProgram(tt.program)
+			c.Strict = true
+
+			var err error
+			for range 10 {
+				if err = c.Step(); err != nil {
+					break
+				}
+			}
+
+			var fault *Fault
+			if !errors.As(err, &fault) {
+				t.Fatalf("Step() = %v, want a *Fault", err)
+			}
+			if fault.Err.Error() != tt.want.Error() {
+				t.Errorf("fault cause = %v, want %v", fault.Err, tt.want)
+			}
+
+			pc := WRAM2.Start + 4
+			if fault.PC != pc || fault.Opcode != tt.program[1] {
+				t.Errorf("fault at %08X executing %08X, want %08X executing %08X", fault.PC, fault.Opcode, pc, tt.program[1])
+			}
+			if len(fault.Trace) != 2 || fault.Trace[1] != (Traced{PC: pc, Opcode: tt.program[1]}) {
+				t.Errorf("trace = %+v, want the program", fault.Trace)
+			}
+
+			if again := c.Step(); again != err {
+				t.Errorf("Step() after fault = %v, want %v", again, err)
+			}
+		})
+	}
+}
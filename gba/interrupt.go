@@ -0,0 +1,72 @@
+package gba
+
+type Interrupt uint16
+
+const (
+	IRQVBlank Interrupt = iota
+	IRQHBlank
+	IRQVCounter
+	IRQTimer0
+	IRQTimer1
+	IRQTimer2
+	IRQTimer3
+	IRQSerial
+	IRQDMA0
+	IRQDMA1
+	IRQDMA2
+	IRQDMA3
+	IRQKeypad
+	IRQGamePak
+)
+
+type InterruptController struct {
+	*Motherboard
+}
+
+func NewInterruptController(m *Motherboard) *InterruptController {
+	return &InterruptController{Motherboard: m}
+}
+
+// Raise requests an interrupt by setting its bit in IF.
+func (i *InterruptController) Raise(irq Interrupt) {
+	SetIORegister(i.Memory, IF, ReadIORegister(i.Memory, IF)|1<<irq)
+}
+
+// Requested reports whether any interrupt enabled in IE has its IF bit set,
+// regardless of IME.
+func (i *InterruptController) Requested() bool {
+	return ReadIORegister(i.Memory, IE)&ReadIORegister(i.Memory, IF)&0x3FFF != 0
+}
+
+// Pending reports whether the CPU should take an IRQ, ignoring the CPSR I bit.
+func (i *InterruptController) Pending() bool {
+	return ReadIORegister(i.Memory, IME)&1 == 1 && i.Requested()
+}
+
+// IRQ drives the CPU's interrupt line.
+func (i *InterruptController) IRQ() bool {
+	return i.Pending()
+}
+
+// checkKeypad raises the keypad interrupt when the keys selected in KEYCNT
+// are held, either any of them or all of them together.
+func (i *InterruptController) checkKeypad() {
+	keycnt := ReadIORegister(i.Memory, KEYCNT)
+	if ReadBits(keycnt, 14, 1) == 0 {
+		return
+	}
+
+	selected := ReadBits(keycnt, 0, 10)
+	held := ^ReadIORegister(i.Memory, KEYINPUT) & selected
+
+	switch ReadBits(keycnt, 15, 1) {
+	case 0: // OR
+		if held != 0 {
+			i.Raise(IRQKeypad)
+		}
+	case 1: // AND
+		if held == selected {
+			i.Raise(IRQKeypad)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package gba
+
+import "testing"
+
+// TestIFAcknowledge expects CPU writes to IF to clear the bits written as 1
+// and leave the rest requested, whatever the width of the write.
+func TestIFAcknowledge(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(m *Memory)
+		want   uint16
+		wantIE uint16
+	}{
+		{"halfword", func(m *Memory) { m.Set16(uint32(IF), 1<<IRQTimer0, false, false) }, 0x3011, 0},
+		{"zero", func(m *Memory) { m.Set16(uint32(IF), 0, false, false) }, 0x3019, 0},
+		{"all", func(m *Memory) { m.Set16(uint32(IF), 0xFFFF, false, false) }, 0, 0},
+		{"low byte", func(m *Memory) { m.Set8(uint32(IF), 0x11, false, false) }, 0x3008, 0},
+		{"high byte", func(m *Memory) { m.Set8(uint32(IF)+1, 0x10, false, false) }, 0x2019, 0},
+		{"word with IE", func(m *Memory) { m.Set32(uint32(IE), 0x00011234, false, false) }, 0x3018, 0x1234},
+		{"raised again", func(m *Memory) {
+			m.Set16(uint32(IF), 0xFFFF, false, false)
+			m.Interrupts.Raise(IRQDMA0)
+		}, 1 << IRQDMA0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMotherboard(nil)
+			for _, irq := range []Interrupt{IRQVBlank, IRQTimer0, IRQTimer1, IRQKeypad, IRQGamePak} {
+				m.Interrupts.Raise(irq)
+			}
+
+			tt.write(m.Memory)
+
+			if got := ReadIORegister(m.Memory, IF); got != tt.want {
+				t.Errorf("IF = %04X, want %04X", got, tt.want)
+			}
+			if got := ReadIORegister(m.Memory, IE); got != tt.wantIE {
+				t.Errorf("IE = %04X, want %04X", got, tt.wantIE)
+			}
+		})
+	}
+}
+
+// TestIRQ expects the CPU to take an IRQ before its next instruction only
+// when IME is set, an interrupt enabled in IE is requested in IF, and the
+// CPSR I bit is clear.
+func TestIRQ(t *testing.T) {
+	tests := []struct {
+		name        string
+		ime, ie, iF uint16
+		disabled    bool
+		thumb       bool
+		taken       bool
+	}{
+		{name: "taken", ime: 1, ie: 1 << IRQVBlank, iF: 1 << IRQVBlank, taken: true},
+		{name: "taken in Thumb", ime: 1, ie: 1 << IRQVBlank, iF: 1 << IRQVBlank, thumb: true, taken: true},
+		{name: "IME off", ime: 0, ie: 1 << IRQVBlank, iF: 1 << IRQVBlank},
+		{name: "IME bit 0 only", ime: 0xFFFE, ie: 1 << IRQVBlank, iF: 1 << IRQVBlank},
+		{name: "not enabled", ime: 1, ie: 1 << IRQHBlank, iF: 1 << IRQVBlank},
+		{name: "not requested", ime: 1, ie: 0x3FFF},
+		{name: "one of many", ime: 1, ie: 1<<IRQTimer2 | 1<<IRQSerial, iF: 1<<IRQSerial | 1<<IRQDMA3, taken: true},
+		{name: "I bit set", ime: 1, ie: 1 << IRQVBlank, iF: 1 << IRQVBlank, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "mov r0, #1"
+			if tt.thumb {
+				source = "movs r0, #1"
+			}
+			m := loadSource(source, tt.thumb, func(c *CPU) {
+				c.cpsrSetIRQDisable(0)
+				if tt.disabled {
+					c.cpsrSetIRQDisable(1)
+				}
+			})
+			c := m.CPU
+			SetIORegister(m.Memory, IME, tt.ime)
+			SetIORegister(m.Memory, IE, tt.ie)
+			SetIORegister(m.Memory, IF, tt.iF)
+			cpsr := c.CPSR
+
+			if err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.taken {
+				if c.cpsrMode() != SYS || c.R[0] != 1 {
+					t.Errorf("mode = %02X, r0 = %d, want the instruction run in SYS", c.cpsrMode(), c.R[0])
+				}
+				return
+			}
+
+			if c.cpsrMode() != IRQ || c.curr != 0x18 || c.cpsrState() != 0 || c.cpsrIRQDisable() != 1 {
+				t.Errorf("mode = %02X at %08X, CPSR %08X, want IRQ at 00000018 in ARM with I set", c.cpsrMode(), c.curr, c.CPSR)
+			}
+			if c.R[0] != 0 {
+				t.Errorf("instruction ran before the IRQ was taken")
+			}
+			if want := WRAM2.Start + 4; c.R[14] != want {
+				t.Errorf("LR_irq = %08X, want %08X", c.R[14], want)
+			}
+			if got := *c.spsrAddr(IRQ); got != cpsr {
+				t.Errorf("SPSR_irq = %08X, want %08X", got, cpsr)
+			}
+		})
+	}
+}
+
+// TestIRQBetweenInstructions enables IME while an interrupt is requested,
+// and expects the store to finish and the IRQ to be taken in place of the
+// next instruction rather than during the store.
+func TestIRQBetweenInstructions(t *testing.T) {
+	m := loadSource(`
+	mov	r0, #0x04000000
+	add	r0, r0, #0x200
+	mov	r1, #1
+	strh	r1, [r0, #8]
+	mov	r2, #1
+`, false, func(c *CPU) {
+		c.cpsrSetIRQDisable(0)
+	})
+	c := m.CPU
+	SetIORegister(m.Memory, IE, 1<<IRQVBlank)
+	SetIORegister(m.Memory, IF, 1<<IRQVBlank)
+
+	for range 4 {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.cpsrMode() != SYS || ReadIORegister(m.Memory, IME) != 1 {
+		t.Fatalf("mode = %02X, IME = %d after the store, want SYS and 1", c.cpsrMode(), ReadIORegister(m.Memory, IME))
+	}
+	if want := WRAM2.Start + 16; c.curr != want {
+		t.Fatalf("stopped at %08X after the store, want %08X", c.curr, want)
+	}
+
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if c.cpsrMode() != IRQ || c.R[2] != 0 {
+		t.Errorf("mode = %02X, r2 = %d, want the IRQ taken before the next instruction", c.cpsrMode(), c.R[2])
+	}
+	if want := WRAM2.Start + 16 + 4; c.R[14] != want {
+		t.Errorf("LR_irq = %08X, want %08X", c.R[14], want)
+	}
+}
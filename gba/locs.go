@@ -5,21 +5,22 @@ type MemoryBlock struct {
 	Size       uint32
 	Reads      [3]bool
 	Writes     [3]bool
-	Cycles     [3]uint32
+	Width      uint32 // bus width in bytes
+	Waits      uint32 // cartridge regions take theirs from WAITCNT
 }
 
 var (
-	BIOS    = MemoryBlock{0x00000000, 0x00003FFF, 16 * k, [3]bool{true, true, true}, [3]bool{false, false, false}, [3]uint32{1, 1, 1}}
-	WRAM1   = MemoryBlock{0x02000000, 0x02FFFFFF, 256 * k, [3]bool{true, true, true}, [3]bool{true, true, true}, [3]uint32{3, 3, 6}}
-	WRAM2   = MemoryBlock{0x03000000, 0x03FFFFFF, 32 * k, [3]bool{true, true, true}, [3]bool{true, true, true}, [3]uint32{1, 1, 1}}
-	IOR     = MemoryBlock{0x04000000, 0x040003FE, 0x3FE, [3]bool{true, true, true}, [3]bool{true, true, true}, [3]uint32{1, 1, 1}}
-	Palette = MemoryBlock{0x05000000, 0x05FFFFFF, 1 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, [3]uint32{1, 1, 2}}
-	VRAM    = MemoryBlock{0x06000000, 0x06017FFF, 96 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, [3]uint32{1, 1, 1}}
-	OAM     = MemoryBlock{0x07000000, 0x07FFFFFF, 64 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, [3]uint32{1, 1, 1}}
-	GPRom1  = MemoryBlock{0x08000000, 0x09FFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, [3]uint32{5, 5, 8}}
-	GPRom2  = MemoryBlock{0x0A000000, 0x0BFFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, [3]uint32{5, 5, 8}}
-	GPRom3  = MemoryBlock{0x0C000000, 0x0DFFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, [3]uint32{5, 5, 8}}
-	GPSRAM  = MemoryBlock{0x0E000000, 0xFFFFFFFF, 64 * k, [3]bool{true, false, false}, [3]bool{true, false, false}, [3]uint32{5, 5, 5}}
+	BIOS    = MemoryBlock{0x00000000, 0x00003FFF, 16 * k, [3]bool{true, true, true}, [3]bool{false, false, false}, 4, 0}
+	WRAM1   = MemoryBlock{0x02000000, 0x02FFFFFF, 256 * k, [3]bool{true, true, true}, [3]bool{true, true, true}, 2, 2}
+	WRAM2   = MemoryBlock{0x03000000, 0x03FFFFFF, 32 * k, [3]bool{true, true, true}, [3]bool{true, true, true}, 4, 0}
+	IOR     = MemoryBlock{0x04000000, 0x040003FE, 0x3FE, [3]bool{true, true, true}, [3]bool{true, true, true}, 4, 0}
+	Palette = MemoryBlock{0x05000000, 0x05FFFFFF, 1 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, 2, 0}
+	VRAM    = MemoryBlock{0x06000000, 0x06017FFF, 96 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, 2, 0}
+	OAM     = MemoryBlock{0x07000000, 0x07FFFFFF, 64 * k, [3]bool{true, true, true}, [3]bool{false, true, true}, 4, 0}
+	GPRom1  = MemoryBlock{0x08000000, 0x09FFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, 2, 0}
+	GPRom2  = MemoryBlock{0x0A000000, 0x0BFFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, 2, 0}
+	GPRom3  = MemoryBlock{0x0C000000, 0x0DFFFFFF, 32 * m, [3]bool{true, true, true}, [3]bool{false, false, false}, 2, 0}
+	GPSRAM  = MemoryBlock{0x0E000000, 0xFFFFFFFF, 64 * k, [3]bool{true, false, false}, [3]bool{true, false, false}, 1, 0}
 )
 
 type IORegister[S Size] uint32
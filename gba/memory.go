@@ -4,6 +4,11 @@ type Memory struct {
 	*Motherboard
 
 	Blocks []BlockData
+
+	waits    [16]waitstate
+	prefetch prefetcher
+
+	events BusEvents
 }
 
 type BlockData struct {
@@ -18,10 +23,10 @@ func NewMemory(mm *Motherboard) *Memory {
 
 	// BIOS writes here
 	x4000410 := MemoryBlock{
-		Start:  0x4000410,
-		End:    0x4000410,
-		Size:   1,
-		Cycles: [3]uint32{1, 1, 1},
+		Start: 0x4000410,
+		End:   0x4000410,
+		Size:  1,
+		Width: 4,
 	}
 
 	blocks := []MemoryBlock{BIOS, WRAM1, WRAM2, IOR, Palette, VRAM, OAM, GPSRAM, x4000410}
@@ -35,35 +40,43 @@ func NewMemory(mm *Motherboard) *Memory {
 	m.Blocks = append(m.Blocks, BlockData{GPRom2, GPRom})
 	m.Blocks = append(m.Blocks, BlockData{GPRom3, GPRom})
 
+	m.initWaitstates()
+
 	return m
 }
 
 func (m *Memory) ReadMemoryBlock(mb MemoryBlock) []byte {
-	return m.addrBlockData(mb.Start).Data
+	return m.blockData(mb)
 }
 
 func (m *Memory) SetMemoryBlock(mb MemoryBlock, value []byte) {
-	copy(m.addrBlockData(mb.Start).Data, value)
+	copy(m.blockData(mb), value)
 }
 
-func (m *Memory) addrBlockData(address uint32) BlockData {
+func (m *Memory) addrBlockData(address uint32) (BlockData, bool) {
 	for _, bd := range m.Blocks {
 		if address < bd.MemoryBlock.Start || address > bd.MemoryBlock.End {
 			continue
 		}
 
-		return bd
+		return bd, true
 	}
 
-	panic(address)
+	return BlockData{}, false
 }
 
-func (m *Memory) block(bd BlockData, address uint32) ([]byte, uint32) {
-	return bd.Data, (address - bd.MemoryBlock.Start) % bd.MemoryBlock.Size
+// unmapped stops the CPU on an access to an address with nothing behind it.
+func (m *Memory) unmapped(address, size uint32, write bool) {
+	m.events.Fault(&BusError{Address: address, Size: size, Write: write, Reason: "unmapped address"})
 }
 
-func (m *Memory) cycle(bd BlockData, size uint32) {
-	m.CPU.cycle(bd.MemoryBlock.Cycles[size])
+func (m *Memory) blockData(mb MemoryBlock) []byte {
+	bd, _ := m.addrBlockData(mb.Start)
+	return bd.Data
+}
+
+func (m *Memory) block(bd BlockData, address uint32) ([]byte, uint32) {
+	return bd.Data, (address - bd.MemoryBlock.Start) % bd.MemoryBlock.Size
 }
 
 func (m *Memory) checkDMA(address uint32) {
@@ -74,17 +87,24 @@ func (m *Memory) checkDMA(address uint32) {
 	m.DMA.transfer(DMAImmediate)
 }
 
-func (m *Memory) setTimerL(address uint32, value uint16, forceAddr bool) bool {
-	if !forceAddr {
+// setTimerL sends CPU writes to TMxCNT_L to the timer's reload value, which
+// the counter only takes up when the timer starts or overflows. Writes the
+// emulator makes for itself set the counter. A byte write replaces its half
+// of the reload.
+func (m *Memory) setTimerL(address uint32, value uint16, size uint32, forceAddr bool) bool {
+	if forceAddr {
 		return false
 	}
-	switch address {
-	case uint32(TM0CNT_L), uint32(TM1CNT_L), uint32(TM2CNT_L), uint32(TM3CNT_L):
-		m.Timer.Set(address, value)
-		return true
-	default:
+	i, ok := timerAddrIndex[address&^1]
+	if !ok {
 		return false
 	}
+
+	if size == 1 {
+		value = SetBits(m.Timer.reloads[i], uint8(address&1)*8, 8, value)
+	}
+	m.Timer.Set(address&^1, value)
+	return true
 }
 
 func (m *Memory) checkTimerH(address uint32, value uint16) {
@@ -94,48 +114,102 @@ func (m *Memory) checkTimerH(address uint32, value uint16) {
 		currState := ReadBits(value, 7, 1)
 
 		if prevState == 0 && currState == 1 {
-			m.Timer.Reload(address)
+			m.Timer.Reload(address - 2)
+		}
+	}
+}
+
+// checkIF turns CPU writes to IF into acknowledgements, clearing each bit
+// written as 1 rather than storing the value.
+func (m *Memory) checkIF(address uint32, value uint32, size uint32, forceAddr bool) uint32 {
+	if forceAddr {
+		return value
+	}
+
+	for i := uint32(0); i < size; i++ {
+		if address+i != uint32(IF) && address+i != uint32(IF)+1 {
+			continue
+		}
+
+		shift := uint8(i * 8)
+		current := uint32(m.Read8(address+i, false, true))
+		ack := ReadBits(value, shift, 8)
+		value = SetBits(value, shift, 8, current&^ack)
+	}
+
+	return value
+}
+
+func (m *Memory) checkHALTCNT(address uint32, value uint32, size uint32, forceAddr bool) {
+	if forceAddr {
+		return
+	}
+
+	for i := uint32(0); i < size; i++ {
+		if address+i == uint32(HALTCNT) {
+			m.events.Halt(ReadBits(value, uint8(i*8)+7, 1) == 1)
 		}
 	}
 }
 
+// checkCode drops any translated blocks a write lands in.
+func (m *Memory) checkCode(address uint32) {
+	m.events.Write(address)
+}
+
 func (m *Memory) Read8(address uint32, cycle bool, forceAddr bool) (value uint8) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 1, false)
+		return 0
+	}
 	//if !bd.MemoryBlock.Reads[0] {
 	//	panic(fmt.Sprintf("cannot read 8 bits from %08X", address))
 	//}
 	if cycle {
-		m.cycle(bd, 0)
+		m.cycle(address, 1, false)
 	}
 	block, offset := m.block(bd, address)
 	return block[offset]
 }
 
 func (m *Memory) Set8(address uint32, value uint8, cycle bool, forceAddr bool) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 1, true)
+		return
+	}
 	//if !bd.MemoryBlock.Writes[0] {
 	//	panic(fmt.Sprintf("cannot write 8 bits to %08X", address))
 	//}
 	if cycle {
-		m.cycle(bd, 0)
+		m.cycle(address, 1, false)
 	}
-	if m.setTimerL(address, uint16(value), forceAddr) {
-		panic("cannot Set timer to 8bit")
+	if m.setTimerL(address, uint16(value), 1, forceAddr) {
+		return
 	}
 	m.checkTimerH(address, uint16(value))
+	value = uint8(m.checkIF(address, uint32(value), 1, forceAddr))
 	block, offset := m.block(bd, address)
 	block[offset] = value
 	m.checkDMA(address)
+	m.checkHALTCNT(address, uint32(value), 1, forceAddr)
+	m.checkWAITCNT(address, 1)
+	m.checkCode(address)
 }
 
 func (m *Memory) Read16(address uint32, cycle bool, forceAddr bool) (value uint16) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 2, false)
+		return 0
+	}
 	//if !bd.MemoryBlock.Reads[1] {
 	//	panic(fmt.Sprintf("cannot read 16 bits from %08X", address))
 	//}
 	address &= ^uint32(1)
 	if cycle {
-		m.cycle(bd, 1)
+		m.cycle(address, 2, false)
 	}
 	block, offset := m.block(bd, address)
 	value = uint16(block[offset])
@@ -145,32 +219,44 @@ func (m *Memory) Read16(address uint32, cycle bool, forceAddr bool) (value uint1
 }
 
 func (m *Memory) Set16(address uint32, value uint16, cycle bool, forceAddr bool) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 2, true)
+		return
+	}
 	//if !bd.MemoryBlock.Writes[1] {
 	//	panic(fmt.Sprintf("cannot write 16 bits to %08X", address))
 	//}
 	address &= ^uint32(1)
 	if cycle {
-		m.cycle(bd, 1)
+		m.cycle(address, 2, false)
 	}
-	if m.setTimerL(address, value, forceAddr) {
+	if m.setTimerL(address, value, 2, forceAddr) {
 		return
 	}
 	m.checkTimerH(address, value)
+	value = uint16(m.checkIF(address, uint32(value), 2, forceAddr))
 	block, offset := m.block(bd, address)
 	block[offset] = uint8(value)
 	block[offset+1] = uint8(value >> 8)
 	m.checkDMA(address)
+	m.checkHALTCNT(address, uint32(value), 2, forceAddr)
+	m.checkWAITCNT(address, 2)
+	m.checkCode(address)
 }
 
 func (m *Memory) Read32(address uint32, cycle bool, forceAddr bool) (value uint32) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 4, false)
+		return 0
+	}
 	//if !bd.MemoryBlock.Reads[2] {
 	//	panic(fmt.Sprintf("cannot read 32 bits from %08X", address))
 	//}
 	address &= ^uint32(3)
 	if cycle {
-		m.cycle(bd, 2)
+		m.cycle(address, 4, false)
 	}
 	block, offset := m.block(bd, address)
 	value = uint32(block[offset])
@@ -181,39 +267,49 @@ func (m *Memory) Read32(address uint32, cycle bool, forceAddr bool) (value uint3
 }
 
 func (m *Memory) Set32(address uint32, value uint32, cycle bool, forceAddr bool) {
-	bd := m.addrBlockData(address)
+	bd, ok := m.addrBlockData(address)
+	if !ok {
+		m.unmapped(address, 4, true)
+		return
+	}
 	//if !bd.MemoryBlock.Writes[2] {
 	//	panic(fmt.Sprintf("cannot write 32 bits to %08X", address))
 	//}
 	address &= ^uint32(3)
 	if cycle {
-		m.cycle(bd, 2)
+		m.cycle(address, 4, false)
 	}
-	if m.setTimerL(address, uint16(value), forceAddr) {
-		panic("cannot Set timer to 32bit")
+	if m.setTimerL(address, uint16(value), 2, forceAddr) {
+		// the top half is TMxCNT_H, which may start the timer
+		m.Set16(address+2, uint16(value>>16), false, false)
+		return
 	}
 	m.checkTimerH(address, uint16(value))
+	value = m.checkIF(address, value, 4, forceAddr)
 	block, offset := m.block(bd, address)
 	block[offset] = uint8(value)
 	block[offset+1] = uint8(value >> 8)
 	block[offset+2] = uint8(value >> 16)
 	block[offset+3] = uint8(value >> 24)
 	m.checkDMA(address)
+	m.checkHALTCNT(address, value, 4, forceAddr)
+	m.checkWAITCNT(address, 4)
+	m.checkCode(address)
 }
 
 func (m *Memory) ClearBlock(mb MemoryBlock) {
-	clear(m.addrBlockData(mb.Start).Data)
+	clear(m.blockData(mb))
 }
 
 func ReadIORegister[S Size](m *Memory, r IORegister[S]) S {
 	v := *new(S)
 	switch t := any(v).(type) {
 	case uint8:
-		v = S(m.Read8(uint32(r), true, true))
+		v = S(m.Read8(uint32(r), false, true))
 	case uint16:
-		v = S(m.Read16(uint32(r), true, true))
+		v = S(m.Read16(uint32(r), false, true))
 	case uint32:
-		v = S(m.Read32(uint32(r), true, true))
+		v = S(m.Read32(uint32(r), false, true))
 	default:
 		panic(t)
 	}
@@ -223,11 +319,11 @@ func ReadIORegister[S Size](m *Memory, r IORegister[S]) S {
 func SetIORegister[S Size](m *Memory, r IORegister[S], value S) {
 	switch t := any(value).(type) {
 	case uint8:
-		m.Set8(uint32(r), uint8(value), true, true)
+		m.Set8(uint32(r), uint8(value), false, true)
 	case uint16:
-		m.Set16(uint32(r), uint16(value), true, true)
+		m.Set16(uint32(r), uint16(value), false, true)
 	case uint32:
-		m.Set32(uint32(r), uint32(value), true, true)
+		m.Set32(uint32(r), uint32(value), false, true)
 	default:
 		panic(t)
 	}
@@ -5,27 +5,33 @@ import (
 )
 
 type Motherboard struct {
-	CPU    *CPU
-	Memory *Memory
-	LCD    *LCD
-	DMA    *DMAController
-	Timer  *Timer
+	CPU        *CPU
+	Memory     *Memory
+	LCD        *LCD
+	DMA        *DMAController
+	Timer      *Timer
+	Interrupts *InterruptController
 }
 
+// biosKey is XORed with each byte of the BIOS image to store it.
+const biosKey = 0x69
+
 func NewMotherboard(gamepak []byte) *Motherboard {
 	m := &Motherboard{}
 
-	m.CPU = NewCPU(m)
 	m.Memory = NewMemory(m)
+	m.Interrupts = NewInterruptController(m)
+	m.CPU = NewCPU(cpuBus{m.Memory}, m.Interrupts)
 	m.LCD = NewLCD(m)
 	m.DMA = NewDMA(m)
 	m.Timer = NewTimer(m)
 
-	for i := range bios {
-		bios[i] ^= 0x69
+	rom := bios
+	for i := range rom {
+		rom[i] ^= biosKey
 	}
 
-	m.Memory.SetMemoryBlock(BIOS, bios[:])
+	m.Memory.SetMemoryBlock(BIOS, rom[:])
 	m.Memory.SetMemoryBlock(GPRom1, gamepak)
 
 	return m
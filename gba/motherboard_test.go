@@ -0,0 +1,92 @@
+package gba
+
+import (
+	"testing"
+
+	"github.com/dbut2/sapphire/asm"
+)
+
+func TestGamepak(t *testing.T) {
+	rom := asm.MustAssemble(`
+	.arm
+start:	mov	r0, #0
+	mov	r1, #10
+loop:	add	r0, r0, r1
+	subs	r1, r1, #1
+	bne	loop
+	adr	r2, thumb + 1
+	bx	r2
+
+	.thumb
+thumb:	ldr	r3, =0x02000000
+	str	r0, [r3]
+	ldr	r1, [r3]
+	adds	r1, #1
+	strh	r1, [r3, #4]
+done:	b	done
+`, GPRom1.Start)
+
+	m := NewMotherboard(rom)
+	c := m.CPU
+	c.cpsrInitMode(SYS)
+	c.R[15] = GPRom1.Start
+	c.prefetchFlush()
+	c.flushed = false
+
+	for range 100 {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := m.Memory.Read32(WRAM1.Start, false, false); got != 55 {
+		t.Errorf("sum = %d, want 55", got)
+	}
+	if got := m.Memory.Read16(WRAM1.Start+4, false, false); got != 56 {
+		t.Errorf("sum + 1 = %d, want 56", got)
+	}
+	if c.cpsrState() != 1 {
+		t.Errorf("still in ARM state")
+	}
+}
+
+func TestBusEvents(t *testing.T) {
+	m := NewMotherboard(nil)
+	bus := cpuBus{m.Memory}
+
+	var (
+		cycles uint32
+		faults []error
+		halts  []bool
+		writes []uint32
+	)
+	bus.Attach(BusEvents{
+		Cycle: func(n uint32) { cycles += n },
+		Fault: func(err error) { faults = append(faults, err) },
+		Halt:  func(stop bool) { halts = append(halts, stop) },
+		Write: func(address uint32) { writes = append(writes, address) },
+	})
+
+	bus.Read32(WRAM1.Start, 0)
+	bus.Idle(3)
+	if cycles != 6+3 {
+		t.Errorf("charged %d cycles, want 9", cycles)
+	}
+
+	bus.Write32(WRAM2.Start+8, 1, 0)
+	if len(writes) != 1 || writes[0] != WRAM2.Start+8 {
+		t.Errorf("writes = %08X, want [%08X]", writes, WRAM2.Start+8)
+	}
+
+	bus.Write8(uint32(HALTCNT), 0x00, 0)
+	bus.Write8(uint32(HALTCNT), 0x80, 0)
+	if len(halts) != 2 || halts[0] || !halts[1] {
+		t.Errorf("halts = %v, want [false true]", halts)
+	}
+
+	bus.Read32(0x4000, 0)
+	want := &BusError{Address: 0x4000, Size: 4, Reason: "unmapped address"}
+	if len(faults) != 1 || faults[0].Error() != want.Error() {
+		t.Errorf("faults = %v, want [%v]", faults, want)
+	}
+}
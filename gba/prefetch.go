@@ -0,0 +1,71 @@
+package gba
+
+// prefetcher models the Game Pak prefetch buffer. While the cartridge bus is
+// otherwise idle it reads ahead from ROM into an 8 halfword FIFO, so that
+// sequential instruction fetches can be served in a single cycle.
+type prefetcher struct {
+	enabled bool
+	active  bool
+
+	head  uint32 // address of the next halfword the CPU will fetch
+	count uint32 // halfwords buffered from head
+	wait  uint32 // cycles until the halfword being read arrives
+	duty  uint32 // cycles taken to read each halfword
+}
+
+// run lets the buffer fill for the given number of cycles.
+func (p *prefetcher) run(cycles uint32) {
+	if !p.active {
+		return
+	}
+
+	for cycles > 0 && p.count < 8 {
+		if cycles < p.wait {
+			p.wait -= cycles
+			return
+		}
+
+		cycles -= p.wait
+		p.count++
+		p.wait = p.duty
+	}
+}
+
+// fetch serves an instruction fetch from the buffer, waiting for any
+// halfwords still being read. It returns false if the buffer does not hold
+// the address.
+func (p *prefetcher) fetch(address, size uint32) (uint32, bool) {
+	if !p.active || address != p.head {
+		return 0, false
+	}
+
+	need := size / 2
+
+	cycles := uint32(1)
+	if p.count < need {
+		cycles = p.wait + (need-p.count-1)*p.duty
+	}
+
+	p.run(cycles)
+	p.count -= need
+	p.head += size
+
+	return cycles, true
+}
+
+// restart begins reading ahead from address.
+func (p *prefetcher) restart(address, duty uint32) {
+	if !p.enabled {
+		return
+	}
+
+	p.active = true
+	p.head = address
+	p.count = 0
+	p.wait = duty
+	p.duty = duty
+}
+
+func (p *prefetcher) stop() {
+	p.active = false
+}
@@ -0,0 +1,122 @@
+package gba
+
+import "testing"
+
+// TestPrefetch follows the prefetch buffer through runs of ROM fetches,
+// with WS0 at its reset waitstates: 5 cycles non-sequential and 3
+// sequential for each halfword.
+func TestPrefetch(t *testing.T) {
+	const rom = 0x08000000
+
+	type step struct {
+		fetch  uint32 // address fetched, if idle and data are zero
+		size   uint32
+		seq    bool
+		idle   uint32 // internal cycles run instead
+		data   uint32 // address of a data read made instead
+		cycles uint32 // cycles charged for a fetch or data read
+	}
+
+	tests := []struct {
+		name     string
+		disabled bool
+		steps    []step
+	}{
+		{
+			name: "disabled",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{idle: 12},
+				{fetch: rom + 2, size: 2, seq: true, cycles: 3},
+				{fetch: rom + 4, size: 2, seq: true, cycles: 3},
+			},
+			disabled: true,
+		},
+		{
+			name: "sequential after internal cycles",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{idle: 12},
+				{fetch: rom + 2, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 4, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 6, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 8, size: 2, seq: true, cycles: 1},
+			},
+		},
+		{
+			name: "ARM fetches take two halfwords",
+			steps: []step{
+				{fetch: rom, size: 4, cycles: 8},
+				{idle: 12},
+				{fetch: rom + 4, size: 4, seq: true, cycles: 1},
+				{fetch: rom + 8, size: 4, seq: true, cycles: 1},
+			},
+		},
+		{
+			name: "waits for a halfword on its way",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{idle: 1},
+				{fetch: rom + 2, size: 2, seq: true, cycles: 2},
+			},
+		},
+		{
+			name: "ROM data read stops the buffer",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{idle: 12},
+				{data: rom + 0x100, cycles: 5},
+				{fetch: rom + 2, size: 2, cycles: 5},
+			},
+		},
+		{
+			name: "other data reads let it run",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{data: WRAM1.Start, cycles: 3},
+				{data: WRAM1.Start + 2, cycles: 3},
+				{fetch: rom + 2, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 4, size: 2, seq: true, cycles: 1},
+			},
+		},
+		{
+			name: "branch restarts it",
+			steps: []step{
+				{fetch: rom, size: 2, cycles: 5},
+				{idle: 12},
+				{fetch: rom + 0x40, size: 2, cycles: 5},
+				{idle: 6},
+				{fetch: rom + 0x42, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 0x44, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 0x46, size: 2, seq: true, cycles: 1},
+				{fetch: rom + 0x48, size: 2, seq: true, cycles: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMotherboard(nil)
+			if !tt.disabled {
+				m.Memory.Set16(uint32(WAITCNT), 0x4000, false, false)
+			}
+
+			for i, s := range tt.steps {
+				before := m.CPU.cycles
+				switch {
+				case s.idle != 0:
+					m.Memory.idle(s.idle)
+					continue
+				case s.data != 0:
+					m.Memory.cycle(s.data, 2, false)
+				default:
+					m.Memory.fetch(s.fetch, s.size, s.seq)
+				}
+
+				if got := m.CPU.cycles - before; got != s.cycles {
+					t.Errorf("step %d: took %d cycles, want %d", i, got, s.cycles)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package gba
+
+// maxBlock bounds how many instructions a translated block holds, which in
+// turn bounds how long the CPU runs between timer and interrupt checks.
+const maxBlock = 32
+
+// block is a run of straight-line instructions translated into closures with
+// their decoding already done.
+type block struct {
+	key   uint32
+	ops   []func(c *CPU)
+	valid bool
+}
+
+// blockCache holds translated blocks keyed by PC and CPU state, along with the
+// blocks living on each writable code page so writes can invalidate them.
+type blockCache struct {
+	blocks map[uint32]*block
+	pages  [codePages][]*block
+}
+
+const (
+	codePageShift = 8
+	codePages     = (256*k + 32*k + 96*k) >> codePageShift // WRAM1, WRAM2 and VRAM
+)
+
+// codePage returns the page of writable memory an address falls in, after
+// resolving mirrors. Code can only be changed in WRAM and VRAM.
+func codePage(address uint32) (uint32, bool) {
+	switch address >> 24 {
+	case 0x02:
+		return (address - WRAM1.Start) % WRAM1.Size >> codePageShift, true
+	case 0x03:
+		return (WRAM1.Size + (address-WRAM2.Start)%WRAM2.Size) >> codePageShift, true
+	case 0x06:
+		if address > VRAM.End {
+			return 0, false
+		}
+		return (WRAM1.Size + WRAM2.Size + address - VRAM.Start) >> codePageShift, true
+	default:
+		return 0, false
+	}
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{blocks: make(map[uint32]*block)}
+}
+
+func (bc *blockCache) lookup(c *CPU) *block {
+	key := c.curr | c.cpsrState()
+	if b, ok := bc.blocks[key]; ok {
+		return b
+	}
+
+	b := c.translate(key)
+	bc.blocks[key] = b
+
+	first, ok := codePage(c.curr)
+	if !ok {
+		return b
+	}
+	last, _ := codePage(c.curr + uint32(len(b.ops)-1)*c.instructionSize())
+	for page := first; page <= last; page++ {
+		bc.pages[page] = append(bc.pages[page], b)
+	}
+
+	return b
+}
+
+// invalidate drops every block on the page an address falls in.
+func (bc *blockCache) invalidate(address uint32) {
+	if bc == nil {
+		return
+	}
+
+	page, ok := codePage(address)
+	if !ok || len(bc.pages[page]) == 0 {
+		return
+	}
+
+	for _, b := range bc.pages[page] {
+		b.valid = false
+		if bc.blocks[b.key] == b {
+			delete(bc.blocks, b.key)
+		}
+	}
+	bc.pages[page] = nil
+}
+
+// translate decodes instructions from the current PC until one that may change
+// the flow of execution, the block limit, or the end of the memory region.
+func (c *CPU) translate(key uint32) *block {
+	b := &block{key: key, valid: true}
+
+	size := c.instructionSize()
+	for addr := c.curr &^ (size - 1); len(b.ops) < maxBlock; addr += size {
+		var op func(c *CPU)
+		var last bool
+		switch size {
+		case 4:
+			op, last = translateArm(c.bus.Read32(addr, Debug))
+		case 2:
+			op, last = translateThumb(uint32(c.bus.Read16(addr, Debug)))
+		}
+		b.ops = append(b.ops, op)
+
+		if last || (addr+size)>>24 != addr>>24 {
+			break
+		}
+	}
+
+	return b
+}
+
+func translateArm(instruction uint32) (func(c *CPU), bool) {
+	index := armIndex(instruction)
+	class := armClasses[index]
+
+	exec := armTable[index]
+	if class == ArmClassALU {
+		if alu := translateALU(instruction); alu != nil {
+			exec = alu
+		}
+	}
+
+	op := func(c *CPU) {
+		exec(c, instruction)
+	}
+	if cond := ReadBits(instruction, 28, 4); cond != 0b1110 {
+		op = func(c *CPU) {
+			if c.cond(cond) {
+				exec(c, instruction)
+			}
+		}
+	}
+
+	return op, armEndsBlock(class, instruction)
+}
+
+// translateALU specialises data processing instructions whose second operand
+// is a plain register or an immediate that leaves the carry alone, and whose
+// flag updates are known from the encoding. Anything else uses the generic
+// handler.
+func translateALU(instruction uint32) handler {
+	op := &aluOps[ReadBits(instruction, 21, 4)]
+	S := ReadBits(instruction, 20, 1)
+	Rn := ReadBits(instruction, 16, 4)
+	Rd := ReadBits(instruction, 12, 4)
+
+	if Rd == 15 {
+		return nil
+	}
+
+	var operand func(c *CPU) uint32
+	switch {
+	case ReadBits(instruction, 25, 1) == 1 && (S == 0 || ReadBits(instruction, 8, 4) == 0):
+		Op2, _ := ShiftROR(ReadBits(instruction, 0, 8), ReadBits(instruction, 8, 4)*2)
+		operand = func(c *CPU) uint32 {
+			return Op2
+		}
+	case ReadBits(instruction, 25, 1) == 0 && ReadBits(instruction, 4, 8) == 0:
+		Rm := ReadBits(instruction, 0, 4)
+		operand = func(c *CPU) uint32 {
+			return c.R[Rm]
+		}
+	default:
+		return nil
+	}
+
+	if S == 0 {
+		return func(c *CPU, _ uint32) {
+			c.R[Rd] = uint32(op.do(c.R[Rn], operand(c), c.cpsrC()))
+		}
+	}
+
+	return func(c *CPU, _ uint32) {
+		left, right := c.R[Rn], operand(c)
+		value := op.do(left, right, c.cpsrC())
+		if !op.void {
+			c.R[Rd] = uint32(value)
+		}
+
+		if op.logic {
+			N, Z, _ := FlagLogic(value, false)
+			c.cpsrSetZ(Z)
+			c.cpsrSetN(N)
+			return
+		}
+
+		N, Z, C, V := op.flagger(left, right, value)
+		c.cpsrSetV(V)
+		c.cpsrSetC(C)
+		c.cpsrSetZ(Z)
+		c.cpsrSetN(N)
+	}
+}
+
+func armEndsBlock(class ArmClass, instruction uint32) bool {
+	switch class {
+	case ArmClassUndefined, ArmClassBranchX, ArmClassBranch, ArmClassSWI, ArmClassPSR:
+		return true
+	case ArmClassMemoryBlock:
+		return ReadBits(instruction, 20, 1) == 1 && (ReadBits(instruction, 15, 1) == 1 || ReadBits(instruction, 0, 16) == 0)
+	case ArmClassMultiply, ArmClassMultiplyLong:
+		return ReadBits(instruction, 16, 4) == 15
+	default:
+		return ReadBits(instruction, 12, 4) == 15
+	}
+}
+
+func translateThumb(instruction uint32) (func(c *CPU), bool) {
+	index := thumbIndex(instruction)
+	exec := thumbTable[index]
+
+	op := func(c *CPU) {
+		exec(c, instruction)
+	}
+
+	return op, thumbEndsBlock(thumbClasses[index], instruction)
+}
+
+func thumbEndsBlock(class ThumbClass, instruction uint32) bool {
+	switch class {
+	case ThumbClassUndefined, ThumbClassSWI, ThumbClassBranchCond, ThumbClassBranchUncond, ThumbClassBranchLink2:
+		return true
+	case ThumbClassHiReg:
+		Rd := ReadBits(instruction, 0, 3) + ReadBits(instruction, 7, 1)<<3
+		return ReadBits(instruction, 8, 2) == 0b11 || Rd == 15
+	case ThumbClassPushPop:
+		return ReadBits(instruction, 11, 1) == 1 && (ReadBits(instruction, 8, 1) == 1 || ReadBits(instruction, 0, 8) == 0)
+	case ThumbClassMemoryBlock:
+		return ReadBits(instruction, 11, 1) == 1 && ReadBits(instruction, 0, 8) == 0
+	default:
+		return false
+	}
+}
+
+// StepBlock runs the translated block at the current PC, translating it first
+// if it is not cached. The interpreter in Step stays the reference: StepBlock
+// defers to it whenever an interrupt is due or the pipeline does not hold the
+// instructions following the PC. Each instruction is timed exactly as Step
+// would time it, but timers and interrupts only see the CPU between blocks.
+func (c *CPU) StepBlock() error {
+	size := c.instructionSize()
+	if c.fault != nil || c.cpsrIRQDisable() == 0 && c.irq.IRQ() || c.next != c.curr+size || c.R[15] != c.next+size {
+		return c.Step()
+	}
+
+	if c.blocks == nil {
+		c.blocks = newBlockCache()
+	}
+	b := c.blocks.lookup(c)
+
+	for _, op := range b.ops {
+		fetched := c.fetch(c.R[15]&^(size-1), size)
+		c.record()
+		op(c)
+
+		if c.flushed {
+			c.flushed = false
+			return c.Err()
+		}
+
+		c.curr = c.next
+		c.next = c.R[15]
+		c.pipeline = [2]uint32{c.pipeline[1], fetched}
+		c.R[15] += size
+
+		if !b.valid || c.halted || c.fault != nil || c.next != c.curr+size {
+			return c.Err()
+		}
+	}
+
+	return nil
+}
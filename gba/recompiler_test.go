@@ -0,0 +1,98 @@
+package gba
+
+import (
+	"testing"
+)
+
+// selfModifying counts to 10 in r0, then patches the loop body to add 2
+// instead of 1 and counts again, leaving 30 in r0 before spinning at 0x1C.
+var selfModifying = []uint32{
+	0xE3A00000, // 00: MOV r0, #0
+	0xE3A0100A, // 04: MOV r1, #10
+	0xE2800001, // 08: ADD r0, r0, #1
+	0xE2511001, // 0C: SUBS r1, r1, #1
+	0x1AFFFFFC, // 10: BNE 08
+	0xE3550000, // 14: CMP r5, #0
+	0x0A000000, // 18: BEQ 20
+	0xEAFFFFFE, // 1C: B 1C
+	0xE3A05001, // 20: MOV r5, #1
+	0xE59F2008, // 24: LDR r2, [pc, #8]
+	0xE5872008, // 28: STR r2, [r7, #8]
+	0xE3A0100A, // 2C: MOV r1, #10
+	0xEAFFFFF4, // 30: B 08
+	0xE2800002, // 34: ADD r0, r0, #2
+}
+
+func loadProgram(program []uint32) *CPU {
+	m := NewMotherboard(nil)
+	c := m.CPU
+	c.cpsrInitMode(SYS)
+
+	for i, instruction := range program {
+		m.Memory.Set32(WRAM2.Start+uint32(i)*4, instruction, false, false)
+	}
+
+	c.R[7] = WRAM2.Start
+	c.R[15] = WRAM2.Start
+	c.prefetchFlush()
+	c.flushed = false
+
+	return c
+}
+
+func TestStepBlock(t *testing.T) {
+	interpreted := loadProgram(selfModifying)
+	recompiled := loadProgram(selfModifying)
+
+	spin := WRAM2.Start + 0x1C
+	for range 1000 {
+		if interpreted.curr == spin && interpreted.R[5] == 1 {
+			break
+		}
+		interpreted.Step()
+	}
+	for range 1000 {
+		if recompiled.curr == spin && recompiled.R[5] == 1 {
+			break
+		}
+		recompiled.StepBlock()
+	}
+
+	if interpreted.R[0] != 30 {
+		t.Fatalf("interpreter counted to %d, want 30", interpreted.R[0])
+	}
+	if recompiled.CPURegisters != interpreted.CPURegisters {
+		t.Errorf("registers differ:\nrecompiled  %08X\ninterpreted %08X", recompiled.R, interpreted.R)
+	}
+	if recompiled.cycles != interpreted.cycles {
+		t.Errorf("recompiled took %d cycles, interpreted %d", recompiled.cycles, interpreted.cycles)
+	}
+}
+
+func BenchmarkStepBlock(b *testing.B) {
+	loop := []uint32{
+		0xE2800001, // ADD r0, r0, #1
+		0xE0811000, // ADD r1, r1, r0
+		0xE2522001, // SUBS r2, r2, #1
+		0xE1A03001, // MOV r3, r1
+		0xE0234001, // EOR r4, r3, r1
+		0xEAFFFFF9, // B 00
+	}
+
+	b.Run("Step", func(b *testing.B) {
+		c := loadProgram(loop)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.Step()
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "instr/s")
+	})
+	b.Run("StepBlock", func(b *testing.B) {
+		c := loadProgram(loop)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.StepBlock()
+		}
+		b.ReportMetric(float64(b.N*len(loop))/b.Elapsed().Seconds(), "instr/s")
+	})
+}
@@ -1,52 +1,7 @@
 package gba
 
 func (c *CPU) Thumb(instruction uint32) {
-	switch {
-	case instruction&0b1111_1111_1111_1111 == 0b0000_0000_0000_0000:
-		noins(instruction)
-	case instruction&0b1111_1111_0000_0000 == 0b1101_1111_0000_0000:
-		c.ThumbSWI(instruction)
-	case instruction&0b1111_1100_0000_0000 == 0b0100_0000_0000_0000:
-		c.ThumbALU(instruction)
-	case instruction&0b1111_1100_0000_0000 == 0b0100_0100_0000_0000:
-		c.ThumbHiReg(instruction)
-	case instruction&0b1111_1000_0000_0000 == 0b0001_1000_0000_0000:
-		c.ThumbAddSub(instruction)
-	case instruction&0b1111_1000_0000_0000 == 0b0100_1000_0000_0000:
-		c.ThumbMemoryPCRel(instruction)
-	case instruction&0b1111_0010_0000_0000 == 0b0101_0000_0000_0000:
-		c.ThumbMemoryReg(instruction)
-	case instruction&0b1110_0000_0000_0000 == 0b0110_0000_0000_0000:
-		c.ThumbMemoryImm(instruction)
-	case instruction&0b1111_0010_0000_0000 == 0b0101_0010_0000_0000:
-		c.ThumbMemoryHalfSign(instruction)
-	case instruction&0b1111_0000_0000_0000 == 0b1000_0000_0000_0000:
-		c.ThumbMemoryHalf(instruction)
-	case instruction&0b1111_0000_0000_0000 == 0b1001_0000_0000_0000:
-		c.ThumbMemorySPRel(instruction)
-	case instruction&0b1111_0000_0000_0000 == 0b1010_0000_0000_0000:
-		c.ThumbMemoryPCSP(instruction)
-	case instruction&0b1111_0000_0000_0000 == 0b1100_0000_0000_0000:
-		c.ThumbMemoryBlock(instruction)
-	case instruction&0b1110_0000_0000_0000 == 0b0000_0000_0000_0000:
-		c.ThumbShift(instruction)
-	case instruction&0b1110_0000_0000_0000 == 0b0010_0000_0000_0000:
-		c.ThumbImm(instruction)
-	case instruction&0b1111_0000_0000_0000 == 0b1101_0000_0000_0000:
-		c.ThumbBranchCond(instruction)
-	case instruction&0b1111_1000_0000_0000 == 0b1110_0000_0000_0000:
-		c.ThumbBranchUncond(instruction)
-	case instruction&0b1111_1000_0000_0000 == 0b1111_0000_0000_0000:
-		c.ThumbBranchLink1(instruction)
-	case instruction&0b1110_1000_0000_0000 == 0b1110_1000_0000_0000:
-		c.ThumbBranchLink2(instruction)
-	case instruction&0b1111_0110_0000_0000 == 0b1011_0100_0000_0000:
-		c.ThumbPushPop(instruction)
-	case instruction&0b1111_1111_0000_0000 == 0b1011_0000_0000_0000:
-		c.ThumbAddSP(instruction)
-	default:
-		noins(instruction)
-	}
+	thumbTable[thumbIndex(instruction)](c, instruction)
 }
 
 func (c *CPU) ThumbShift(instruction uint32) {
@@ -57,16 +12,13 @@ func (c *CPU) ThumbShift(instruction uint32) {
 	Rd := ReadBits(instruction, 0, 3)
 
 	var value uint32
-	var carry bool
+	carry := c.cpsrC() == 1
 	switch Opcode {
-	case 0b00:
-		value, carry = ShiftLSL(c.R[Rs], Offset)
-	case 0b01:
-		value, carry = ShiftLSR(c.R[Rs], Offset)
-	case 0b10:
-		value, carry = ShiftASR(c.R[Rs], Offset)
+	case LSL, LSR, ASR:
+		value, carry = ShiftImmediate(Opcode, c.R[Rs], Offset, carry)
 	default:
-		noins(instruction)
+		c.undefined(instruction)
+		return
 	}
 
 	c.R[Rd] = value
@@ -161,7 +113,7 @@ func (c *CPU) ThumbImm(instruction uint32) {
 	switch Opcode {
 	case 0b00:
 		value := MOV(left, right, 0)
-		N, Z, _, _ := FlagLogic(left, right, value)
+		N, Z, _ := FlagLogic(value, false)
 		c.cpsrSetN(N)
 		c.cpsrSetZ(Z)
 		c.R[Rd] = uint32(value)
@@ -209,26 +161,26 @@ func (c *CPU) ThumbALU(instruction uint32) {
 	case 0b0000: // AND
 		value := AND(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	case 0b0001: // EOR
 		value := EOR(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	case 0b0010: // LSL
-		value, carry := ShiftLSL(left, right&0xFF)
+		value, carry := Shift(LSL, left, right, C)
 		c.R[Rd] = value
-		N, Z, _, _ = FlagLogic(left, right, uint64(value))
-		C = carry
+		c.idle(1)
+		N, Z, C = FlagLogic(uint64(value), carry)
 	case 0b0011: // LSR
-		value, carry := ShiftLSR(left, right&0xFF)
+		value, carry := Shift(LSR, left, right, C)
 		c.R[Rd] = value
-		N, Z, _, _ = FlagLogic(left, right, uint64(value))
-		C = carry
+		c.idle(1)
+		N, Z, C = FlagLogic(uint64(value), carry)
 	case 0b0100: // ASR
-		value, carry := ShiftASR(left, right&0xFF)
+		value, carry := Shift(ASR, left, right, C)
 		c.R[Rd] = value
-		N, Z, _, _ = FlagLogic(left, right, uint64(value))
-		C = carry
+		c.idle(1)
+		N, Z, C = FlagLogic(uint64(value), carry)
 	case 0b0101: // ADC
 		value := ADC(left, right, Cy)
 		c.R[Rd] = uint32(value)
@@ -238,13 +190,13 @@ func (c *CPU) ThumbALU(instruction uint32) {
 		c.R[Rd] = uint32(value)
 		N, Z, C, V = FlagArithSub(left, right, value)
 	case 0b0111: // ROR
-		value, carry := ShiftROR(left, right&0xFF)
+		value, carry := Shift(ROR, left, right, C)
 		c.R[Rd] = value
-		N, Z, _, _ = FlagLogic(left, right, uint64(value))
-		C = carry
+		c.idle(1)
+		N, Z, C = FlagLogic(uint64(value), carry)
 	case 0b1000: // TST
 		value := TST(left, right, Cy)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	case 0b1001: // NEG
 		value := SUB(0, right, Cy)
 		c.R[Rd] = uint32(value)
@@ -258,19 +210,20 @@ func (c *CPU) ThumbALU(instruction uint32) {
 	case 0b1100: // ORR
 		value := ORR(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	case 0b1101: // MUL
 		value := MUL(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, C, V = FlagArithAdd(left, right, value)
+		c.idle(multiplyCycles(left, true))
+		N, Z, _ = FlagLogic(value, C)
 	case 0b1110: // BIC
 		value := BIC(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	case 0b1111: // MVN
 		value := MVN(left, right, Cy)
 		c.R[Rd] = uint32(value)
-		N, Z, _, _ = FlagLogic(left, right, value)
+		N, Z, _ = FlagLogic(value, C)
 	}
 
 	c.cpsrSetN(N)
@@ -403,33 +356,14 @@ func (c *CPU) ThumbPush(instruction uint32) {
 	Lr := ReadBits(instruction, 8, 1)
 	Rlist := ReadBits(instruction, 0, 8)
 
-	if Lr == 1 {
-		c.R[13] -= 4
-		c.Memory.Set32(c.R[13], c.R[14], true, false)
-	}
-	for i := 7; i >= 0; i-- {
-		if Rlist>>i&1 == 1 {
-			c.R[13] -= 4
-			c.Memory.Set32(c.R[13], c.R[i], true, false)
-		}
-	}
+	c.stm(13, Rlist|Lr<<14, 1, 0, 1, 0)
 }
 
 func (c *CPU) ThumbPop(instruction uint32) {
-	Lr := ReadBits(instruction, 8, 1)
+	Pc := ReadBits(instruction, 8, 1)
 	Rlist := ReadBits(instruction, 0, 8)
 
-	for i := 0; i <= 7; i++ {
-		if Rlist>>i&1 == 1 {
-			c.R[i] = c.Memory.Read32(c.R[13], true, false)
-			c.R[13] += 4
-		}
-	}
-	if Lr == 1 {
-		c.R[15] = c.Memory.Read32(c.R[13], true, false)
-		c.R[13] += 4
-		c.prefetchFlush()
-	}
+	c.ldm(13, Rlist|Pc<<15, 0, 1, 1, 0)
 }
 
 func (c *CPU) ThumbMemorySPRel(instruction uint32) {
@@ -439,9 +373,10 @@ func (c *CPU) ThumbMemorySPRel(instruction uint32) {
 
 	switch Opcode {
 	case 0:
-		c.Memory.Set32(c.R[13]+nn, c.R[Rd], true, false)
+		c.write32(c.R[13]+nn, c.R[Rd])
 	case 1:
-		c.R[Rd] = c.Memory.Read32(c.R[13]+nn, true, false)
+		c.R[Rd] = c.readWord(c.R[13] + nn)
+		c.idle(1)
 	}
 }
 
@@ -461,8 +396,9 @@ func (c *CPU) ThumbMemoryPCRel(instruction uint32) {
 	Rd := ReadBits(instruction, 8, 3)
 	nn := ReadBits(instruction, 0, 8) << 2
 
-	value := c.Memory.Read32(c.R[15]&^2+nn, true, false)
+	value := c.read32(c.R[15]&^2 + nn)
 	c.R[Rd] = value
+	c.idle(1)
 }
 
 func (c *CPU) ThumbMemoryReg(instruction uint32) {
@@ -480,7 +416,7 @@ func (c *CPU) Thumb_STR(instruction uint32) {
 	Ro := ReadBits(instruction, 6, 3)
 
 	value := c.R[Rd]
-	c.Memory.Set32(c.R[Rb]+c.R[Ro], value, true, false)
+	c.write32(c.R[Rb]+c.R[Ro], value)
 }
 
 func (c *CPU) Thumb_STRB(instruction uint32) {
@@ -489,7 +425,7 @@ func (c *CPU) Thumb_STRB(instruction uint32) {
 	Ro := ReadBits(instruction, 6, 3)
 
 	value := uint8(c.R[Rd])
-	c.Memory.Set8(c.R[Rb]+c.R[Ro], value, true, false)
+	c.write8(c.R[Rb]+c.R[Ro], value)
 }
 
 func (c *CPU) Thumb_LDR(instruction uint32) {
@@ -497,8 +433,9 @@ func (c *CPU) Thumb_LDR(instruction uint32) {
 	Rb := ReadBits(instruction, 3, 3)
 	Ro := ReadBits(instruction, 6, 3)
 
-	value := c.Memory.Read32(c.R[Rb]+c.R[Ro], true, false)
+	value := c.readWord(c.R[Rb] + c.R[Ro])
 	c.R[Rd] = value
+	c.idle(1)
 }
 
 func (c *CPU) Thumb_LDRB(instruction uint32) {
@@ -506,8 +443,9 @@ func (c *CPU) Thumb_LDRB(instruction uint32) {
 	Rb := ReadBits(instruction, 3, 3)
 	Ro := ReadBits(instruction, 6, 3)
 
-	value := uint32(c.Memory.Read8(c.R[Rb]+c.R[Ro], true, false))
+	value := uint32(c.read8(c.R[Rb] + c.R[Ro]))
 	c.R[Rd] = value
+	c.idle(1)
 }
 
 func (c *CPU) ThumbMemoryImm(instruction uint32) {
@@ -519,15 +457,17 @@ func (c *CPU) ThumbMemoryImm(instruction uint32) {
 	switch Opcode {
 	case 0b00: // STR
 		nn <<= 2
-		c.Memory.Set32(c.R[Rb]+nn, c.R[Rd], true, false)
+		c.write32(c.R[Rb]+nn, c.R[Rd])
 	case 0b01: // LDR
 		nn <<= 2
-		value := c.Memory.Read32(c.R[Rb]+nn, true, false)
+		value := c.readWord(c.R[Rb] + nn)
 		c.R[Rd] = value
+		c.idle(1)
 	case 0b10: // STRB
-		c.Memory.Set8(c.R[Rb]+nn, uint8(c.R[Rd]), true, false)
+		c.write8(c.R[Rb]+nn, uint8(c.R[Rd]))
 	case 0b11: // LDRB
-		c.R[Rd] = uint32(c.Memory.Read8(c.R[Rb]+nn, true, false))
+		c.R[Rd] = uint32(c.read8(c.R[Rb] + nn))
+		c.idle(1)
 	}
 }
 
@@ -552,41 +492,32 @@ func (c *CPU) ThumbMemoryBlock(instruction uint32) {
 
 	switch Opcode {
 	case 0b0:
-		for i := 0; i <= 7; i++ {
-			if (Rlist>>i)&1 == 1 {
-				c.Memory.Set32(c.R[Rb], c.R[i], true, false)
-				c.R[Rb] += 4
-			}
-		}
+		c.stm(Rb, Rlist, 0, 1, 1, 0)
 	case 0b1:
-		for i := 0; i <= 7; i++ {
-			if (Rlist>>i)&1 == 1 {
-				c.R[i] = c.Memory.Read32(c.R[Rb], true, false)
-				c.R[Rb] += 4
-			}
-		}
+		c.ldm(Rb, Rlist, 0, 1, 1, 0)
 	}
 }
 
 func (c *CPU) ThumbMemorySign(instruction uint32) {
-	noins(instruction) // todo
-}
-
-func (c *CPU) ThumbMemoryHalfSign(instruction uint32) {
 	Opcode := ReadBits(instruction, 10, 2)
 	Ro := ReadBits(instruction, 6, 3)
 	Rb := ReadBits(instruction, 3, 3)
 	Rd := ReadBits(instruction, 0, 3)
 
+	addr := c.R[Rb] + c.R[Ro]
+
 	switch Opcode {
 	case 0b00: // STRH
-		c.Memory.Set16(c.R[Rb]+c.R[Ro], uint16(c.R[Rd]), true, false)
+		c.write16(addr, uint16(c.R[Rd]))
 	case 0b01: // LDSB
-		c.R[Rd] = uint32(signify(uint32(c.Memory.Read8(c.R[Rb]+c.R[Ro], true, false)), 8))
+		c.R[Rd] = uint32(signify(uint32(c.read8(addr)), 8))
+		c.idle(1)
 	case 0b10: // LDRH
-		c.R[Rd] = uint32(c.Memory.Read16(c.R[Rb]+c.R[Ro], true, false))
+		c.R[Rd] = c.readHalf(addr)
+		c.idle(1)
 	case 0b11: // LDSH
-		c.R[Rd] = uint32(signify(uint32(c.Memory.Read16(c.R[Rb]+c.R[Ro], true, false)), 16))
+		c.R[Rd] = c.readHalfSigned(addr)
+		c.idle(1)
 	}
 }
 
@@ -598,8 +529,9 @@ func (c *CPU) ThumbMemoryHalf(instruction uint32) {
 
 	switch Opcode {
 	case 0b0: // STRH
-		c.Memory.Set16(c.R[Rb]+nn, uint16(c.R[Rd]), true, false)
+		c.write16(c.R[Rb]+nn, uint16(c.R[Rd]))
 	case 0b1: // LDRH
-		c.R[Rd] = uint32(c.Memory.Read16(c.R[Rb]+nn, true, false))
+		c.R[Rd] = c.readHalf(c.R[Rb] + nn)
+		c.idle(1)
 	}
 }
@@ -13,16 +13,17 @@ func NewTimer(m *Motherboard) *Timer {
 
 var prescalerValues = [4]uint32{1, 64, 256, 1024}
 
+// Tick advances the timers by a number of cycles. A timer counts once per
+// period of its prescaler, and each prescaler carries the cycles left over
+// towards its next period, so the count is the same however the cycles are
+// split between calls.
 func (t *Timer) Tick(cycles uint32) {
 	incs := [4]uint32{}
 
 	for i := range prescalerValues {
 		t.timers[i] += cycles
-
-		if t.timers[i] >= prescalerValues[i] {
-			incs[i] = prescalerValues[i]
-			t.timers[i] %= prescalerValues[i]
-		}
+		incs[i] = t.timers[i] / prescalerValues[i]
+		t.timers[i] %= prescalerValues[i]
 	}
 
 	timer, overflowed := t.tick(TM0CNT_L, TM0CNT_H, incs, false)
@@ -70,16 +71,40 @@ func (t *Timer) tick(regL, regH IORegister[uint16], incs [4]uint32, prevOverflow
 	if inced > 1<<16-1 {
 		overflowed = true
 
-		cntL = t.reloads[timerIndex[regL]]
+		reload := uint32(t.reloads[timerIndex[regL]])
+		cntL = uint16(reload + (inced-1<<16)%(1<<16-reload))
 
 		if irqEnable == 1 {
-			t.CPU.exception(0x18)
+			t.Interrupts.Raise(IRQTimer0 + Interrupt(timerIndex[regL]))
 		}
 	}
 
 	return cntL, overflowed
 }
 
+// nextOverflow returns the number of cycles until a timer counting on its
+// own next overflows, and false if none is running. Count-up timers only
+// overflow after another timer does, so they are never first.
+func (t *Timer) nextOverflow() (uint32, bool) {
+	next, ok := uint32(0), false
+
+	for i, regH := range [...]IORegister[uint16]{TM0CNT_H, TM1CNT_H, TM2CNT_H, TM3CNT_H} {
+		cntH := ReadIORegister(t.Memory, regH)
+		if ReadBits(cntH, 7, 1) == 0 || ReadBits(cntH, 2, 1) == 1 {
+			continue
+		}
+
+		prescaler := ReadBits(cntH, 0, 2)
+		counts := 1<<16 - uint32(ReadIORegister(t.Memory, indexTimer[i]))
+		cycles := counts*prescalerValues[prescaler] - t.timers[prescaler]
+		if !ok || cycles < next {
+			next, ok = cycles, true
+		}
+	}
+
+	return next, ok
+}
+
 func (t *Timer) Set(address uint32, value uint16) {
 	t.reloads[timerAddrIndex[address]] = value
 }
@@ -0,0 +1,83 @@
+package gba
+
+import "testing"
+
+func TestTimerTick(t *testing.T) {
+	tests := []struct {
+		name      string
+		reload    uint16
+		control   uint16
+		ticks     []uint32
+		want      uint16
+		overflows bool
+	}{
+		{"F/1", 0, 0x80, []uint32{1000}, 1000, false},
+		{"F/64", 0, 0x81, []uint32{1000}, 15, false},
+		{"F/64 split", 0, 0x81, []uint32{100, 100, 100, 100, 100, 100, 100, 100, 100, 100}, 15, false},
+		{"F/64 carried", 0, 0x81, []uint32{63, 1}, 1, false},
+		{"F/256", 0x10, 0x82, []uint32{4096}, 0x20, false},
+		{"F/1024", 0, 0x83, []uint32{3000, 3000}, 5, false},
+		{"stopped", 0, 0x00, []uint32{1000}, 0, false},
+		{"overflow reloads", 0xFFF0, 0xC0, []uint32{20}, 0xFFF4, true},
+		{"overflow without IRQ", 0xFFF0, 0x80, []uint32{20}, 0xFFF4, false},
+		{"overflow to zero", 0, 0xC0, []uint32{0x10000}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMotherboard(nil)
+			m.Memory.Set16(uint32(TM0CNT_L), tt.reload, false, false)
+			m.Memory.Set16(uint32(TM0CNT_H), tt.control, false, false)
+
+			for _, cycles := range tt.ticks {
+				m.Timer.Tick(cycles)
+			}
+
+			if got := ReadIORegister(m.Memory, TM0CNT_L); got != tt.want {
+				t.Errorf("TM0CNT_L = %04X, want %04X", got, tt.want)
+			}
+			if got := ReadIORegister(m.Memory, IF)&(1<<IRQTimer0) != 0; got != tt.overflows {
+				t.Errorf("timer 0 IRQ requested = %t, want %t", got, tt.overflows)
+			}
+		})
+	}
+}
+
+func TestTimerCountUp(t *testing.T) {
+	m := NewMotherboard(nil)
+	m.Memory.Set32(uint32(TM0CNT_L), 0x0080FFFF, false, false)
+	m.Memory.Set32(uint32(TM1CNT_L), 0x00C4FFFE, false, false)
+
+	m.Timer.Tick(1)
+	if got := ReadIORegister(m.Memory, TM1CNT_L); got != 0xFFFF {
+		t.Fatalf("TM1CNT_L = %04X after timer 0 overflowed, want FFFF", got)
+	}
+	m.Timer.Tick(1)
+	if got := ReadIORegister(m.Memory, TM1CNT_L); got != 0xFFFE {
+		t.Errorf("TM1CNT_L = %04X after overflowing, want FFFE", got)
+	}
+	if ReadIORegister(m.Memory, IF)&(1<<IRQTimer1) == 0 {
+		t.Errorf("timer 1 IRQ not requested")
+	}
+}
+
+func TestTimerNextOverflow(t *testing.T) {
+	m := NewMotherboard(nil)
+	if _, ok := m.Timer.nextOverflow(); ok {
+		t.Fatalf("overflow expected with no timers running")
+	}
+
+	m.Memory.Set32(uint32(TM0CNT_L), 0x0081FFFE, false, false) // 2 counts at F/64
+	m.Memory.Set32(uint32(TM1CNT_L), 0x0084FFFF, false, false) // count-up
+	m.Memory.Set32(uint32(TM2CNT_L), 0x0080FF00, false, false) // 256 counts at F/1
+	m.Timer.Tick(10)
+
+	if got, ok := m.Timer.nextOverflow(); !ok || got != 2*64-10 {
+		t.Errorf("nextOverflow() = %d, %t, want %d", got, ok, 2*64-10)
+	}
+
+	m.Timer.Tick(2*64 - 10)
+	if got, ok := m.Timer.nextOverflow(); !ok || got != 256-2*64 {
+		t.Errorf("nextOverflow() = %d, %t after timer 0 overflowed, want %d", got, ok, 256-2*64)
+	}
+}
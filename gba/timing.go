@@ -0,0 +1,145 @@
+package gba
+
+// stream follows a run of accesses on the bus, so that an access continuing
+// from the previous one can be charged as sequential.
+type stream struct {
+	next  uint32
+	valid bool
+}
+
+func (s *stream) access(address, size uint32) (seq bool) {
+	seq = s.valid && s.next == address
+	s.next = address + size
+	s.valid = true
+	return seq
+}
+
+func (s *stream) reset() {
+	s.valid = false
+}
+
+// region indexes the 16MB areas of the memory map, with everything past the
+// cartridge SRAM treated as SRAM.
+func region(address uint32) uint32 {
+	return min(address>>24, 0xF)
+}
+
+// waitstate tracks the bus width and the extra cycles taken by
+// non-sequential and sequential accesses in each region.
+type waitstate struct {
+	width uint32
+	n, s  uint32
+}
+
+func (m *Memory) initWaitstates() {
+	for i := range m.waits {
+		m.waits[i] = waitstate{width: 4}
+	}
+
+	for _, bd := range m.Blocks {
+		mb := bd.MemoryBlock
+		for r := region(mb.Start); r <= region(mb.End); r++ {
+			m.waits[r] = waitstate{width: mb.Width, n: mb.Waits, s: mb.Waits}
+		}
+	}
+
+	m.updateWaitstates()
+}
+
+var (
+	cartN   = [4]uint32{4, 3, 2, 8}
+	cartWS0 = [2]uint32{2, 1}
+	cartWS1 = [2]uint32{4, 1}
+	cartWS2 = [2]uint32{8, 1}
+)
+
+// updateWaitstates applies the cartridge waitstates selected in WAITCNT.
+func (m *Memory) updateWaitstates() {
+	waitcnt := ReadIORegister(m, WAITCNT)
+
+	sram := cartN[ReadBits(waitcnt, 0, 2)]
+	ws0 := waitstate{width: 2, n: cartN[ReadBits(waitcnt, 2, 2)], s: cartWS0[ReadBits(waitcnt, 4, 1)]}
+	ws1 := waitstate{width: 2, n: cartN[ReadBits(waitcnt, 5, 2)], s: cartWS1[ReadBits(waitcnt, 7, 1)]}
+	ws2 := waitstate{width: 2, n: cartN[ReadBits(waitcnt, 8, 2)], s: cartWS2[ReadBits(waitcnt, 10, 1)]}
+
+	m.waits[0x8], m.waits[0x9] = ws0, ws0
+	m.waits[0xA], m.waits[0xB] = ws1, ws1
+	m.waits[0xC], m.waits[0xD] = ws2, ws2
+	m.waits[0xE] = waitstate{width: 1, n: sram, s: sram}
+	m.waits[0xF] = m.waits[0xE]
+
+	m.prefetch.enabled = ReadBits(waitcnt, 14, 1) == 1
+	if !m.prefetch.enabled {
+		m.prefetch.stop()
+	}
+}
+
+func (m *Memory) checkWAITCNT(address uint32, size uint32) {
+	if address+size <= uint32(WAITCNT) || address > uint32(WAITCNT)+1 {
+		return
+	}
+
+	m.updateWaitstates()
+}
+
+// accessCycles returns the cycles taken by an access of size bytes. Accesses
+// wider than the bus are split, with the later halves always sequential.
+func (m *Memory) accessCycles(address, size uint32, seq bool) uint32 {
+	ws := m.waits[region(address)]
+
+	cycles := 1 + ws.n
+	if seq {
+		cycles = 1 + ws.s
+	}
+
+	if ws.width == 2 && size == 4 {
+		cycles += 1 + ws.s
+	}
+
+	return cycles
+}
+
+func isCartridge(address uint32) bool {
+	return region(address) >= 0x8
+}
+
+func isROM(address uint32) bool {
+	return isCartridge(address) && region(address) < 0xE
+}
+
+// cycle charges the CPU for a data access. Data accesses to the cartridge
+// stop the prefetch buffer, anything else leaves it free to read ahead.
+func (m *Memory) cycle(address, size uint32, seq bool) {
+	cycles := m.accessCycles(address, size, seq)
+	if isCartridge(address) {
+		m.prefetch.stop()
+	} else {
+		m.prefetch.run(cycles)
+	}
+
+	m.events.Cycle(cycles)
+}
+
+// fetch charges the CPU for an instruction fetch, serving ROM fetches from
+// the prefetch buffer where it can.
+func (m *Memory) fetch(address, size uint32, seq bool) {
+	if !isROM(address) {
+		cycles := m.accessCycles(address, size, seq)
+		m.prefetch.run(cycles)
+		m.events.Cycle(cycles)
+		return
+	}
+
+	if cycles, ok := m.prefetch.fetch(address, size); ok {
+		m.events.Cycle(cycles)
+		return
+	}
+
+	m.events.Cycle(m.accessCycles(address, size, seq))
+	m.prefetch.restart(address+size, 1+m.waits[region(address)].s)
+}
+
+// idle lets the prefetch buffer read ahead while the CPU runs internal cycles.
+func (m *Memory) idle(cycles uint32) {
+	m.prefetch.run(cycles)
+}
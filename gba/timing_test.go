@@ -0,0 +1,122 @@
+package gba
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestAccessCycles checks the cycles charged for each access size in each
+// region, with and without the sequential discount.
+func TestAccessCycles(t *testing.T) {
+	type cycles struct{ n8, s8, n16, s16, n32, s32 uint32 }
+
+	tests := []struct {
+		address uint32
+		waitcnt uint16
+		want    cycles
+	}{
+		{BIOS.Start, 0, cycles{1, 1, 1, 1, 1, 1}},
+		{WRAM1.Start, 0, cycles{3, 3, 3, 3, 6, 6}},
+		{WRAM2.Start, 0, cycles{1, 1, 1, 1, 1, 1}},
+		{IOR.Start, 0, cycles{1, 1, 1, 1, 1, 1}},
+		{Palette.Start, 0, cycles{1, 1, 1, 1, 2, 2}},
+		{VRAM.Start, 0, cycles{1, 1, 1, 1, 2, 2}},
+		{OAM.Start, 0, cycles{1, 1, 1, 1, 1, 1}},
+
+		// WS0, WS1 and WS2 at their reset waitstates: 4 non-sequential, and
+		// 2, 4 and 8 sequential
+		{0x08000000, 0, cycles{5, 3, 5, 3, 8, 6}},
+		{0x09000000, 0, cycles{5, 3, 5, 3, 8, 6}},
+		{0x0A000000, 0, cycles{5, 5, 5, 5, 10, 10}},
+		{0x0B000000, 0, cycles{5, 5, 5, 5, 10, 10}},
+		{0x0C000000, 0, cycles{5, 9, 5, 9, 14, 18}},
+		{0x0D000000, 0, cycles{5, 9, 5, 9, 14, 18}},
+		{0x0E000000, 0, cycles{5, 5, 5, 5, 5, 5}},
+		{0x0F000000, 0, cycles{5, 5, 5, 5, 5, 5}},
+
+		// 0x4317, as most games set it: SRAM 8, WS0 3/1, WS1 4/4, WS2 8/8
+		{0x08000000, 0x4317, cycles{4, 2, 4, 2, 6, 4}},
+		{0x0A000000, 0x4317, cycles{5, 5, 5, 5, 10, 10}},
+		{0x0C000000, 0x4317, cycles{9, 9, 9, 9, 18, 18}},
+		{0x0E000000, 0x4317, cycles{9, 9, 9, 9, 9, 9}},
+
+		// the fastest settings: SRAM 2, WS0 2/1, WS1 2/1, WS2 2/1
+		{0x08000000, 0x06DA, cycles{3, 2, 3, 2, 5, 4}},
+		{0x0A000000, 0x06DA, cycles{3, 2, 3, 2, 5, 4}},
+		{0x0C000000, 0x06DA, cycles{3, 2, 3, 2, 5, 4}},
+		{0x0E000000, 0x06DA, cycles{3, 3, 3, 3, 3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%08X WAITCNT %04X", tt.address, tt.waitcnt), func(t *testing.T) {
+			m := NewMotherboard(nil)
+			m.Memory.Set16(uint32(WAITCNT), tt.waitcnt, false, false)
+
+			got := cycles{
+				m.Memory.accessCycles(tt.address, 1, false),
+				m.Memory.accessCycles(tt.address, 1, true),
+				m.Memory.accessCycles(tt.address, 2, false),
+				m.Memory.accessCycles(tt.address, 2, true),
+				m.Memory.accessCycles(tt.address, 4, false),
+				m.Memory.accessCycles(tt.address, 4, true),
+			}
+			if got != tt.want {
+				t.Errorf("N/S 8, 16, 32 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWAITCNTWrites expects each write that touches WAITCNT, at any width,
+// to take effect on the next access.
+func TestWAITCNTWrites(t *testing.T) {
+	m := NewMotherboard(nil)
+
+	m.Memory.Set8(uint32(WAITCNT), 0x14, false, false) // WS0 3/1
+	if got := m.Memory.accessCycles(0x08000000, 2, false); got != 4 {
+		t.Errorf("WS0 N16 = %d after a byte write, want 4", got)
+	}
+
+	m.Memory.Set8(uint32(WAITCNT)+1, 0x03, false, false) // WS2 N 8
+	if got := m.Memory.accessCycles(0x0C000000, 2, false); got != 9 {
+		t.Errorf("WS2 N16 = %d after a write to the high byte, want 9", got)
+	}
+
+	m.Memory.Set32(uint32(WAITCNT), 0, false, false)
+	if got := m.Memory.accessCycles(0x08000000, 2, false); got != 5 {
+		t.Errorf("WS0 N16 = %d after a word write, want 5", got)
+	}
+}
+
+// TestShiftCycles expects a shift by a register to take an internal cycle
+// on top of its fetch from IWRAM, in ARM and in Thumb.
+func TestShiftCycles(t *testing.T) {
+	tests := []struct {
+		source string
+		thumb  bool
+		want   uint32
+	}{
+		{"mov r0, r1, lsl #2", false, 1},
+		{"mov r0, r1, lsl r2", false, 2},
+		{"add r0, r1, r2, ror r3", false, 2},
+		{"lsls r0, r1, #2", true, 1},
+		{"lsls r0, r1", true, 2},
+		{"lsrs r0, r1", true, 2},
+		{"asrs r0, r1", true, 2},
+		{"rors r0, r1", true, 2},
+		{"ands r0, r1", true, 1},
+	}
+
+	for _, tt := range tests {
+		c := loadSource(tt.source, tt.thumb, func(c *CPU) {
+			c.R[1], c.R[2], c.R[3] = 3, 4, 5
+		}).CPU
+		before := c.cycles
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.cycles - before; got != tt.want {
+			t.Errorf("%s took %d cycles, want %d", tt.source, got, tt.want)
+		}
+	}
+}
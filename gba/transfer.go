@@ -0,0 +1,163 @@
+package gba
+
+// cpuFastSet copies or fills words from R0 to R1 in blocks of eight, so the
+// count in R2 is rounded up to a multiple of eight. Bit 24 of R2 selects a
+// fill with the word at R0.
+func (c *CPU) cpuFastSet() {
+	source, destination := c.R[0], c.R[1]
+	size := ReadBits(c.R[2], 0, 21) << 2
+	c.idle(165)
+	if !c.biosSourceValid(source, size) {
+		return
+	}
+
+	end := destination + size
+	if ReadBits(c.R[2], 24, 1) == 1 {
+		value := c.readWord(source)
+		for int32(destination) < int32(end) {
+			for range 8 {
+				c.write32(destination, value)
+				destination += 4
+			}
+			c.idle(16)
+		}
+		return
+	}
+
+	var block [8]uint32
+	for int32(destination) < int32(end) {
+		for i := range block {
+			block[i] = c.read32(source)
+			source += 4
+		}
+		for _, value := range block {
+			c.write32(destination, value)
+			destination += 4
+		}
+		c.idle(14)
+	}
+}
+
+// bitUnPack widens the units of the data at R0 into units of another width
+// at R1, following the UnPackInfo at R2: the source length, source and
+// destination widths, and an offset added to each unit, to zero units too
+// when bit 31 is set. A partly filled last word is never written.
+func (c *CPU) bitUnPack() {
+	source, destination, info := c.R[0], c.R[1], c.R[2]
+	length := int32(c.readHalf(info))
+	c.idle(220)
+	if !c.biosSourceValid(source, uint32(length)) {
+		return
+	}
+
+	srcWidth := uint32(c.read8(info + 2))
+	dstWidth := uint32(c.read8(info + 3))
+	offset := c.readWord(info + 4)
+	zero := offset>>31 == 1
+	offset &^= 0x80000000
+	if srcWidth == 0 {
+		// The BIOS never gets past the first byte, writing words for ever.
+		c.exception(0x08)
+		return
+	}
+
+	var pending, shift uint32
+	for range length {
+		b := uint32(c.read8(source))
+		source++
+		mask := uint32(0xFF) >> ((8 - srcWidth) & 0xFF)
+		c.idle(15)
+
+		for bit := uint32(0); bit < 8; bit += srcWidth {
+			unit := b & mask >> bit
+			if unit != 0 || zero {
+				unit += offset
+			}
+			pending |= unit << shift
+			shift += dstWidth
+			if shift >= 32 {
+				c.write32(destination, pending)
+				destination += 4
+				pending, shift = 0, 0
+			}
+			mask <<= srcWidth
+			c.idle(25)
+		}
+	}
+}
+
+// diff8bitUnFilterWram undoes 8 bit differencing from R0 to R1, writing a
+// byte at a time.
+func (c *CPU) diff8bitUnFilterWram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.read32(source) >> 8)
+	source += 4
+	c.idle(120)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	value := c.read8(source)
+	source++
+	c.write8(destination, value)
+	destination++
+	for size--; size > 0; size-- {
+		value += c.read8(source)
+		source++
+		c.write8(destination, value)
+		destination++
+		c.idle(10)
+	}
+}
+
+// diff8bitUnFilterVram undoes 8 bit differencing from R0 to R1, pairing the
+// bytes into halfwords. A trailing odd byte is never written.
+func (c *CPU) diff8bitUnFilterVram() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.read32(source) >> 8)
+	source += 4
+	c.idle(150)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	value := c.read8(source)
+	source++
+	pending, shift := uint32(value), uint32(8)
+	for size--; size > 0; size-- {
+		value += c.read8(source)
+		source++
+		pending |= uint32(value) << shift
+		shift ^= 8
+		if shift == 0 {
+			c.write16(destination, uint16(pending))
+			destination += 2
+			pending = 0
+		}
+		c.idle(17)
+	}
+}
+
+// diff16bitUnFilter undoes 16 bit differencing from R0 to R1. An odd size
+// is rounded up to whole halfwords.
+func (c *CPU) diff16bitUnFilter() {
+	source, destination := c.R[0], c.R[1]
+	size := int32(c.read32(source) >> 8)
+	source += 4
+	c.idle(120)
+	if !c.biosSourceValid(source-4, uint32(size)) {
+		return
+	}
+
+	value := c.readHalf(source)
+	source += 2
+	c.write16(destination, uint16(value))
+	destination += 2
+	for size -= 2; size > 0; size -= 2 {
+		value += c.readHalf(source)
+		source += 2
+		c.write16(destination, uint16(value))
+		destination += 2
+		c.idle(11)
+	}
+}
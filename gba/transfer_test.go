@@ -0,0 +1,71 @@
+package gba
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// TestTransfer compares the memory transfer calls with the BIOS over random
+// data, including how each rounds its size and aligns its addresses.
+func TestTransfer(t *testing.T) {
+	var (
+		source = WRAM1.Start
+		info   = WRAM1.Start + 0x8000
+		wram   = WRAM1.Start + 0x10000
+		vram   = VRAM.Start + 0x100
+	)
+
+	// unpack returns an UnPackInfo.
+	unpack := func(length uint16, srcWidth, dstWidth uint8, offset uint32) []byte {
+		return []byte{
+			byte(length), byte(length >> 8), srcWidth, dstWidth,
+			byte(offset), byte(offset >> 8), byte(offset >> 16), byte(offset >> 24),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		swi        uint32
+		r0, r1, r2 uint32
+		header     uint32 // written over the source's first word if set
+		info       []byte
+	}{
+		{"fast copy", CpuFastSet, source, wram, 16, 0, nil},
+		{"fast copy rounded", CpuFastSet, source, wram, 5, 0, nil},
+		{"fast copy unaligned", CpuFastSet, source + 1, wram + 2, 17, 0, nil},
+		{"fast fill", CpuFastSet, source, wram, 1<<24 | 10, 0, nil},
+		{"fast fill unaligned", CpuFastSet, source + 2, wram, 1<<24 | 8, 0, nil},
+		{"fast empty", CpuFastSet, source, wram, 0x200000, 0, nil},
+		{"unpack 1 to 4", BitUnPack, source, wram, info, 0, unpack(16, 1, 4, 1)},
+		{"unpack 2 to 8 zero", BitUnPack, source, wram, info, 0, unpack(16, 2, 8, 0x80000005)},
+		{"unpack 4 to 8", BitUnPack, source, wram, info, 0, unpack(33, 4, 8, 0)},
+		{"unpack 8 to 16", BitUnPack, source, wram, info, 0, unpack(16, 8, 16, 0x100)},
+		{"unpack 8 to 32", BitUnPack, source, wram, info, 0, unpack(8, 8, 32, 0x7FFFFFFF)},
+		{"unpack 1 to 3", BitUnPack, source, wram, info, 0, unpack(12, 1, 3, 0)},
+		{"diff8 wram", Diff8bitUnFilterWram, source, wram + 1, 0, 0x4180, nil},
+		{"diff8 vram", Diff8bitUnFilterVram, source, vram, 0, 0x4080, nil},
+		{"diff8 vram odd size", Diff8bitUnFilterVram, source, vram, 0, 0x2180, nil},
+		{"diff16", Diff16bitUnFilter, source, vram, 0, 0x4081, nil},
+		{"diff16 odd size", Diff16bitUnFilter, source, vram, 0, 0x2181, nil},
+		{"diff16 unaligned", Diff16bitUnFilter, source + 2, vram, 0, 0x2081, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := func(m *Motherboard) {
+				r := rand.New(rand.NewPCG(1, 0))
+				for i := range uint32(0x1000) {
+					m.Memory.Set8(source+i, byte(r.Uint32()), false, false)
+				}
+				if tt.header != 0 {
+					m.Memory.Set32(source, tt.header, false, false)
+				}
+				for i, b := range tt.info {
+					m.Memory.Set8(info+uint32(i), b, false, false)
+				}
+				m.CPU.R[0], m.CPU.R[1], m.CPU.R[2] = tt.r0, tt.r1, tt.r2
+			}
+			compareSWI(t, tt.swi, tt.r1, setup)
+		})
+	}
+}
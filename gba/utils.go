@@ -20,13 +20,14 @@ func SetBits[S Size](v S, bit uint8, size uint8, value S) S {
 	return v
 }
 
-func FlagLogic(left, right uint32, value uint64) (N, Z, C, V bool) {
+// FlagLogic gives the flags set by a logical operation, which takes C from the
+// barrel shifter rather than the ALU.
+func FlagLogic(value uint64, carry bool) (N, Z, C bool) {
 	N = uint32(value)>>31 == 1
 	Z = uint32(value) == 0
-	C = false
-	V = false
+	C = carry
 
-	return N, Z, C, V
+	return N, Z, C
 }
 
 func FlagArithAdd(left, right uint32, value uint64) (N, Z, C, V bool) {
@@ -58,7 +59,14 @@ const (
 	ROR
 )
 
-func Shift(shiftType uint32, value, amount uint32) (uint32, bool) {
+// Shift runs the barrel shifter by a register-specified amount, of which only
+// the bottom byte counts. An amount of 0 passes the value and carry through.
+func Shift(shiftType uint32, value, amount uint32, carry bool) (uint32, bool) {
+	amount &= 0xFF
+	if amount == 0 {
+		return value, carry
+	}
+
 	switch shiftType {
 	case LSL:
 		return ShiftLSL(value, amount)
@@ -73,24 +81,73 @@ func Shift(shiftType uint32, value, amount uint32) (uint32, bool) {
 	}
 }
 
+// ShiftImmediate runs the barrel shifter by an amount encoded in the
+// instruction, where LSR #0 and ASR #0 shift by 32 and ROR #0 is RRX.
+func ShiftImmediate(shiftType uint32, value, amount uint32, carry bool) (uint32, bool) {
+	if amount == 0 {
+		switch shiftType {
+		case LSR, ASR:
+			amount = 32
+		case ROR:
+			return ShiftRRX(value, carry)
+		}
+	}
+
+	return Shift(shiftType, value, amount, carry)
+}
+
+// The shifts below return the value and the last bit shifted out. They accept
+// any amount, though for an amount of 0 the carry out means nothing.
+
 func ShiftLSL(value, amount uint32) (uint32, bool) {
-	return value << amount, value&(1<<(32-amount)) > 0
+	switch {
+	case amount == 0:
+		return value, false
+	case amount < 32:
+		return value << amount, value>>(32-amount)&1 == 1
+	case amount == 32:
+		return 0, value&1 == 1
+	default:
+		return 0, false
+	}
 }
 
 func ShiftLSR(value, amount uint32) (uint32, bool) {
-	return value >> amount, value&(1<<(amount-1)) > 0
+	switch {
+	case amount == 0:
+		return value, false
+	case amount < 32:
+		return value >> amount, value>>(amount-1)&1 == 1
+	case amount == 32:
+		return 0, value>>31 == 1
+	default:
+		return 0, false
+	}
 }
 
 func ShiftASR(value, amount uint32) (uint32, bool) {
-	s := value & (1 << 31)
-	for i := uint32(0); i < amount; i++ {
-		value = (value >> 1) | s
+	switch {
+	case amount == 0:
+		return value, false
+	case amount < 32:
+		return uint32(int32(value) >> amount), value>>(amount-1)&1 == 1
+	default:
+		return uint32(int32(value) >> 31), value>>31 == 1
 	}
-	return value, value&(1<<(amount-1)) > 0
 }
 
 func ShiftROR(value, amount uint32) (uint32, bool) {
-	return value>>(amount%32) | value<<(32-(amount%32)), (value>>(amount-1))&1 > 0
+	if amount == 0 {
+		return value, false
+	}
+
+	value = bits.RotateLeft32(value, -int(amount%32))
+	return value, value>>31 == 1
+}
+
+// ShiftRRX rotates right by one through the carry.
+func ShiftRRX(value uint32, carry bool) (uint32, bool) {
+	return bool2uint32(carry)<<31 | value>>1, value&1 == 1
 }
 
 func addInt(a uint32, b int32) uint32 {
@@ -0,0 +1,122 @@
+package gba
+
+import (
+	"fmt"
+	"testing"
+)
+
+var shiftNames = [...]string{LSL: "lsl", LSR: "lsr", ASR: "asr", ROR: "ror"}
+
+type shiftTest struct {
+	shiftType     uint32
+	value, amount uint32
+	carry         bool
+	want          uint32
+	wantCarry     bool
+}
+
+func (tt shiftTest) String() string {
+	return fmt.Sprintf("%s %08X by %d, C=%t", shiftNames[tt.shiftType], tt.value, tt.amount, tt.carry)
+}
+
+func TestShiftImmediate(t *testing.T) {
+	tests := []shiftTest{
+		{LSL, 0x80000001, 0, true, 0x80000001, true},
+		{LSL, 0x80000001, 0, false, 0x80000001, false},
+		{LSL, 0x80000001, 1, false, 0x00000002, true},
+		{LSL, 0x40000000, 31, true, 0, false},
+		{LSR, 0x80000001, 1, false, 0x40000000, true},
+		{LSR, 0x80000000, 0, false, 0, true}, // LSR #32
+		{LSR, 0x7FFFFFFF, 0, true, 0, false},
+		{ASR, 0x80000010, 4, false, 0xF8000001, false},
+		{ASR, 0x80000000, 0, false, 0xFFFFFFFF, true}, // ASR #32
+		{ASR, 0x7FFFFFFF, 0, true, 0, false},
+		{ROR, 0x000000F1, 4, false, 0x1000000F, false},
+		{ROR, 0x00000001, 0, true, 0x80000000, true}, // RRX
+		{ROR, 0x00000001, 0, false, 0, true},
+		{ROR, 0x80000000, 0, false, 0x40000000, false},
+	}
+
+	for _, tt := range tests {
+		if got, carry := ShiftImmediate(tt.shiftType, tt.value, tt.amount, tt.carry); got != tt.want || carry != tt.wantCarry {
+			t.Errorf("%v = %08X, C=%t, want %08X, C=%t", tt, got, carry, tt.want, tt.wantCarry)
+		}
+	}
+}
+
+func TestShiftRegister(t *testing.T) {
+	tests := []shiftTest{
+		// an amount of 0 keeps the value and C, only the bottom byte counts
+		{LSL, 0x80000001, 0, true, 0x80000001, true},
+		{LSR, 0x80000001, 0, false, 0x80000001, false},
+		{ASR, 0x80000001, 0, true, 0x80000001, true},
+		{ROR, 0x80000001, 0, false, 0x80000001, false},
+		{LSL, 0x80000001, 0x100, true, 0x80000001, true},
+		{LSR, 0x80000001, 0xFFFFFF01, false, 0x40000000, true},
+
+		{LSL, 0x00000001, 32, false, 0, true},
+		{LSL, 0xFFFFFFFF, 33, true, 0, false},
+		{LSL, 0xFFFFFFFF, 255, true, 0, false},
+		{LSR, 0x80000000, 32, false, 0, true},
+		{LSR, 0xFFFFFFFF, 33, true, 0, false},
+		{ASR, 0x80000000, 32, false, 0xFFFFFFFF, true},
+		{ASR, 0x80000000, 200, false, 0xFFFFFFFF, true},
+		{ASR, 0x7FFFFFFF, 40, true, 0, false},
+		{ROR, 0x80000001, 32, false, 0x80000001, true},
+		{ROR, 0x7FFFFFFE, 64, true, 0x7FFFFFFE, false},
+		{ROR, 0x000000F8, 36, false, 0x8000000F, true},
+	}
+
+	for _, tt := range tests {
+		if got, carry := Shift(tt.shiftType, tt.value, tt.amount, tt.carry); got != tt.want || carry != tt.wantCarry {
+			t.Errorf("%v = %08X, C=%t, want %08X, C=%t", tt, got, carry, tt.want, tt.wantCarry)
+		}
+	}
+}
+
+// TestShifterCarry expects logical instructions with S set to take C from
+// the barrel shifter, and arithmetic ones to take it from the ALU.
+func TestShifterCarry(t *testing.T) {
+	tests := []struct {
+		source string
+		thumb  bool
+		c      bool // C before
+		wantC  bool
+	}{
+		{"movs r0, r1, lsr #32", false, false, true},
+		{"movs r0, r1, lsl #0", false, true, true},
+		{"movs r0, r1, rrx", false, false, true},
+		{"ands r0, r1, r1, ror r2", false, true, true},
+		{"ands r0, r1, r1, lsr r3", false, true, true},
+		{"eors r0, r1, r1, asr r4", false, false, true},
+		{"tst r1, r1, lsl r4", false, true, false},
+		{"movs r0, #0x80000000", false, false, true},
+		{"movs r0, #0x7F000000", false, true, false},
+		{"movs r0, #1", false, true, true},
+		{"adds r0, r1, r1, lsr #32", false, true, false},
+		{"lsrs r0, r1, #32", true, false, true},
+		{"lsls r0, r1, #0", true, true, true},
+		{"lsrs r1, r2", true, true, true},
+		{"lsrs r1, r5", true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			m := loadSource(tt.source, tt.thumb, func(c *CPU) {
+				c.R[1] = 0x80000001
+				c.R[2] = 0 // register amount of 0
+				c.R[3] = 0x100
+				c.R[4] = 40
+				c.R[5] = 32
+				c.cpsrSetC(tt.c)
+			})
+			if err := m.CPU.Step(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := m.CPU.CPSR>>29&1 == 1; got != tt.wantC {
+				t.Errorf("C = %t, want %t", got, tt.wantC)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	_ "embed"
+	"encoding/binary"
+	"fmt"
 	"image"
 	"os"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/dbut2/dialog"
 	"github.com/spf13/cobra"
 
+	"github.com/dbut2/sapphire/disasm"
 	"github.com/dbut2/sapphire/gba"
 )
 
@@ -23,16 +26,24 @@ func main() {
 	}
 }
 
-func run(gamepak []byte) {
+type options struct {
+	strict    bool
+	recompile bool
+}
+
+func run(gamepak []byte, opts options) {
 	a := app.New()
+	emu := gba.NewEmu(gamepak)
+	emu.CPU.Strict = opts.strict
+	emu.CPU.Recompile = opts.recompile
 	win := window{
-		emu:    gba.NewEmu(gamepak),
+		emu:    emu,
 		window: a.NewWindow("Sapphire"),
 	}
 	win.Start()
 }
 
-func cmd(run func(gamepak []byte)) *cobra.Command {
+func cmd(run func(gamepak []byte, opts options)) *cobra.Command {
 	c := &cobra.Command{
 		Use: "sapphire",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -43,17 +54,95 @@ func cmd(run func(gamepak []byte)) *cobra.Command {
 			if game == "" {
 				game = selectGame()
 			}
+			strict, err := cmd.Flags().GetBool("strict")
+			if err != nil {
+				return err
+			}
+			recompile, err := cmd.Flags().GetBool("recompile")
+			if err != nil {
+				return err
+			}
 			gamepak, err := loadGame(game)
 			if err != nil {
 				return err
 			}
 
-			run(gamepak)
+			run(gamepak, options{strict: strict, recompile: recompile})
 
 			return nil
 		},
 	}
 	c.Flags().StringP("game", "g", "", "Game to load")
+	c.Flags().Bool("strict", false, "Stop on undefined instructions instead of trapping")
+	c.Flags().Bool("recompile", false, "Run translated blocks instead of interpreting each instruction")
+	c.AddCommand(disasmCmd())
+	return c
+}
+
+func disasmCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "disasm",
+		Short: "Disassemble instructions from a ROM",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rom, err := cmd.Flags().GetString("rom")
+			if err != nil {
+				return err
+			}
+			addr, err := cmd.Flags().GetUint32("addr")
+			if err != nil {
+				return err
+			}
+			count, err := cmd.Flags().GetInt("count")
+			if err != nil {
+				return err
+			}
+			thumb, err := cmd.Flags().GetBool("thumb")
+			if err != nil {
+				return err
+			}
+			gamepak, err := loadGame(rom)
+			if err != nil {
+				return err
+			}
+
+			if addr < gba.GPRom1.Start || addr > gba.GPRom3.End {
+				return fmt.Errorf("address %08x is not in the ROM", addr)
+			}
+
+			out := cmd.OutOrStdout()
+			for i := 0; i < count; i++ {
+				offset := (addr - gba.GPRom1.Start) % gba.GPRom1.Size
+
+				switch {
+				case !thumb && offset+4 <= uint32(len(gamepak)):
+					opcode := binary.LittleEndian.Uint32(gamepak[offset:])
+					fmt.Fprintf(out, "%08x:\t%08x\t%s\n", addr, opcode, disasm.Arm(opcode, addr))
+					addr += 4
+				case thumb && offset+2 <= uint32(len(gamepak)):
+					opcode := binary.LittleEndian.Uint16(gamepak[offset:])
+					if offset+4 <= uint32(len(gamepak)) && gba.DecodeThumb(opcode) == gba.ThumbClassBranchLink1 {
+						suffix := binary.LittleEndian.Uint16(gamepak[offset+2:])
+						if gba.DecodeThumb(suffix) == gba.ThumbClassBranchLink2 {
+							fmt.Fprintf(out, "%08x:\t%04x %04x\t%s\n", addr, opcode, suffix, disasm.ThumbBL(opcode, suffix, addr))
+							addr += 4
+							continue
+						}
+					}
+					fmt.Fprintf(out, "%08x:\t%04x\t\t%s\n", addr, opcode, disasm.Thumb(opcode, addr))
+					addr += 2
+				default:
+					return nil
+				}
+			}
+
+			return nil
+		},
+	}
+	c.Flags().String("rom", "", "ROM to disassemble")
+	c.Flags().Uint32("addr", gba.GPRom1.Start, "Address to start at")
+	c.Flags().Int("count", 16, "Number of instructions to disassemble")
+	c.Flags().Bool("thumb", false, "Disassemble Thumb instead of ARM")
+	_ = c.MarkFlagRequired("rom")
 	return c
 }
 
@@ -75,7 +164,11 @@ func (w *window) Start() {
 	w.window.SetContent(cimg)
 	w.window.Resize(fyne.NewSize(240, 160))
 
-	go w.emu.Boot()
+	go func() {
+		if err := w.emu.Boot(); err != nil {
+			crash(err)
+		}
+	}()
 
 	w.window.ShowAndRun()
 }